@@ -1,7 +1,284 @@
 package main
 
-import "log"
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/PyYoshi/etlcdb-tools/formats"
+)
+
+var (
+	format            string
+	etlcdbDir         string
+	datasetsDir       string
+	outputImageWidth  int
+	outputImageHeight int
+	workerNum         int
+	skipCorrupt       bool
+	resume            bool
+	showProgress      bool
+	metadata          string
+	outputs           string
+	shards            int
+	tfrecordRaw       bool
+	shardSamples      int
+	shardBytes        int64
+	layout            string
+	imageFormat       string
+	pngCompression    string
+	pixelScaling      string
+	resizeFilter      string
+	resizeMode        string
+	padColor          string
+	cropInk           bool
+	inkMargin         int
+	inkThreshold      int
+	centerOfMass      bool
+
+	// resizeOptions resizeFilter, resizeMode, padColorから作成したリサイズのオプション
+	resizeOptions formats.ResizeOptions
+)
+
+func init() {
+	flag.StringVar(&format, "format", "", "parse only the given format (1, 2, 3, 4, 5, 6, 7, 8b, 8g, 9b, 9g). parse all formats if omitted")
+	flag.StringVar(&format, "f", "", "shorthand for --format")
+	flag.StringVar(&etlcdbDir, "etlcdb-dir", "", "(required) etlcdb directory path")
+	flag.StringVar(&etlcdbDir, "e", "", "shorthand for --etlcdb-dir")
+	flag.StringVar(&datasetsDir, "datasets-dir", "", "(required) output datasets directory path")
+	flag.StringVar(&datasetsDir, "o", "", "shorthand for --datasets-dir")
+	flag.IntVar(&outputImageWidth, "width", 0, "output image width. keep the original size if both width and height are 0")
+	flag.IntVar(&outputImageHeight, "height", 0, "output image height. keep the original size if both width and height are 0")
+	flag.IntVar(&workerNum, "workers", runtime.NumCPU(), "number of files processed in parallel")
+	flag.IntVar(&workerNum, "j", runtime.NumCPU(), "shorthand for --workers")
+	flag.BoolVar(&skipCorrupt, "skip-corrupt", false, "skip and report corrupt records instead of aborting")
+	flag.StringVar(&outputs, "output", "png", "comma separated outputs to write (png, idx, npy, npz, tfrecord, webdataset)")
+	flag.IntVar(&shards, "shards", 1, "number of TFRecord shards")
+	flag.BoolVar(&tfrecordRaw, "tfrecord-raw", false, "store raw 8-bit grayscale pixels instead of PNG in TFRecord")
+	flag.IntVar(&shardSamples, "shard-samples", 10000, "maximum number of samples per WebDataset tar shard. 0 means no limit")
+	flag.Int64Var(&shardBytes, "shard-bytes", 0, "maximum bytes per WebDataset tar shard. 0 means no limit")
+	flag.StringVar(&layout, "layout", "flat", "image layout of the png output (flat, code, character)")
+	flag.StringVar(&imageFormat, "image-format", "png", "image file format of the png output (png, webp, bmp, tiff, pgm, pbm)")
+	flag.StringVar(&pngCompression, "png-compression", "best", "png compression level (best, default, speed, none)")
+	flag.StringVar(&resizeFilter, "resize-filter", "lanczos", "resampling filter used to resize images (nearest, box, linear, catmullrom, lanczos)")
+	flag.StringVar(&resizeMode, "resize-mode", "stretch", "how to resize to both width and height (stretch, fit, crop)")
+	flag.StringVar(&padColor, "pad-color", "0", "padding color of --resize-mode fit. gray level (0-255) or #rrggbb")
+	flag.BoolVar(&cropInk, "crop-ink", false, "crop each image to the bounding box of the ink before resizing")
+	flag.IntVar(&inkMargin, "ink-margin", 2, "margin in pixels kept around the ink bounding box of --crop-ink")
+	flag.IntVar(&inkThreshold, "ink-threshold", 64, "minimum 8-bit pixel value treated as ink")
+	flag.BoolVar(&centerOfMass, "center-of-mass", false, "center each image on the center of mass of the ink in a square canvas before resizing")
+	flag.StringVar(&pixelScaling, "pixel-scaling", "shift", "how to map the original 1/4/6-bit pixel values (shift, full, native)")
+	flag.StringVar(&metadata, "metadata", "json", "comma separated metadata formats to write (json, jsonl, csv, tsv)")
+	flag.BoolVar(&showProgress, "progress", isTerminal(os.Stderr), "show a live progress line. enabled by default if stderr is a terminal")
+	flag.BoolVar(&resume, "resume", false, "resume an interrupted run, skipping input files that are already done")
+}
 
 func main() {
-	log.Println("Hello World!")
+	flag.Parse()
+	if etlcdbDir == "" || datasetsDir == "" || workerNum < 1 {
+		flag.Usage()
+		os.Exit(1)
+		return
+	}
+
+	specs := formats.Formats()
+	if format != "" {
+		spec, err := formats.LookupFormat(formats.ETLFormat(strings.ToLower(format)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		specs = []*formats.FormatSpec{spec}
+	}
+
+	for _, output := range splitList(outputs) {
+		if _, ok := outputMakers[output]; !ok {
+			log.Fatalf("unknown output: %s", output)
+		}
+	}
+	_, err := formats.NewImageEncoder(formats.ImageEncoding(strings.ToLower(imageFormat)), formats.PNGCompression(strings.ToLower(pngCompression)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	resizeOptions.Filter = formats.ResizeFilter(strings.ToLower(resizeFilter))
+	resizeOptions.Mode = formats.ResizeMode(strings.ToLower(resizeMode))
+	resizeOptions.PadColor, err = parseColor(padColor)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Ctrl-Cで処理中のファイルを破棄して終了する
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		// 2回目のCtrl-Cでは待たずに終了できるようにする
+		signal.Stop(sigCh)
+		log.Println("interrupted, stopping... (press Ctrl-C again to exit immediately)")
+		cancel()
+	}()
+
+	for _, spec := range specs {
+		inputDir := path.Join(etlcdbDir, spec.Name)
+		if _, err := os.Stat(inputDir); err != nil {
+			// フォーマット指定がない場合は存在するディレクトリのみ処理する
+			if format == "" && os.IsNotExist(err) {
+				log.Printf("%s: %s not found, skipped\n", spec.Name, inputDir)
+				continue
+			}
+			log.Fatal(err)
+		}
+
+		outputDir := path.Join(datasetsDir, spec.Name)
+		for _, output := range splitList(outputs) {
+			var progress *progressPrinter
+			var onProgress func(p formats.Progress)
+			if showProgress {
+				progress = newProgressPrinter(os.Stderr, spec.Name)
+				onProgress = progress.update
+				// ファイルごとのログなどで進捗の行が崩れないよう, 処理中のログは進捗の行を消してから出力する
+				log.SetOutput(progress)
+			}
+			err := outputMakers[output](ctx, spec, inputDir, outputDir, onProgress)
+			if progress != nil {
+				progress.finish()
+				log.SetOutput(os.Stderr)
+			}
+			if err != nil {
+				log.Fatalf("%s: %v", spec.Name, err)
+			}
+		}
+	}
+}
+
+// outputMaker 出力形式ごとにデータセットを作成する関数
+type outputMaker func(ctx context.Context, spec *formats.FormatSpec, inputDir, outputDir string, onProgress func(p formats.Progress)) error
+
+// outputMakers --outputで指定できる出力形式
+var outputMakers = map[string]outputMaker{
+	"png":        makePngDatasets,
+	"idx":        exportIDX,
+	"npy":        exportNpy,
+	"npz":        exportNpz,
+	"tfrecord":   exportTFRecord,
+	"webdataset": exportWebDataset,
+}
+
+// makePngDatasets 画像をPNGで出力し, メタデータを出力する
+func makePngDatasets(ctx context.Context, spec *formats.FormatSpec, inputDir, outputDir string, onProgress func(p formats.Progress)) error {
+	var metadataFormats []formats.MetadataFormat
+	for _, m := range splitList(metadata) {
+		metadataFormats = append(metadataFormats, formats.MetadataFormat(m))
+	}
+
+	return formats.MakeDatasetsContext(ctx, spec.Format, inputDir, outputDir, formats.DatasetsOptions{
+		OutputImageWidth:   outputImageWidth,
+		OutputImageHeight:  outputImageHeight,
+		WorkerNum:          workerNum,
+		SkipCorruptRecords: skipCorrupt,
+		Resume:             resume,
+		MetadataFormats:    metadataFormats,
+		Layout:             formats.ImageLayout(strings.ToLower(layout)),
+		Encoding:           formats.ImageEncoding(strings.ToLower(imageFormat)),
+		PNGCompression:     formats.PNGCompression(strings.ToLower(pngCompression)),
+		PixelScaling:       formats.PixelScaling(strings.ToLower(pixelScaling)),
+		Resize:             resizeOptions,
+		Normalize:          normalizeOptions(),
+		OnProgress:         onProgress,
+	})
+}
+
+// exportIDX MNISTと同じIDX形式で出力する
+func exportIDX(ctx context.Context, spec *formats.FormatSpec, inputDir, outputDir string, onProgress func(p formats.Progress)) error {
+	return formats.ExportIDX(ctx, spec.Format, inputDir, outputDir, exportOptions(onProgress))
+}
+
+// exportNpy NumPyの.npy形式で出力する
+func exportNpy(ctx context.Context, spec *formats.FormatSpec, inputDir, outputDir string, onProgress func(p formats.Progress)) error {
+	return formats.ExportNpy(ctx, spec.Format, inputDir, outputDir, exportOptions(onProgress))
+}
+
+// exportNpz NumPyの.npz形式で出力する
+func exportNpz(ctx context.Context, spec *formats.FormatSpec, inputDir, outputDir string, onProgress func(p formats.Progress)) error {
+	return formats.ExportNpz(ctx, spec.Format, inputDir, outputDir, exportOptions(onProgress))
+}
+
+// exportTFRecord TensorFlowのTFRecord形式で出力する
+func exportTFRecord(ctx context.Context, spec *formats.FormatSpec, inputDir, outputDir string, onProgress func(p formats.Progress)) error {
+	return formats.ExportTFRecord(ctx, spec.Format, inputDir, outputDir, formats.TFRecordOptions{
+		ExportOptions: exportOptions(onProgress),
+		Shards:        shards,
+		RawImage:      tfrecordRaw,
+	})
+}
+
+// exportWebDataset WebDatasetの規約に従ったtarのシャードで出力する
+func exportWebDataset(ctx context.Context, spec *formats.FormatSpec, inputDir, outputDir string, onProgress func(p formats.Progress)) error {
+	return formats.ExportWebDataset(ctx, spec.Format, inputDir, outputDir, formats.WebDatasetOptions{
+		ExportOptions:   exportOptions(onProgress),
+		ShardMaxSamples: shardSamples,
+		ShardMaxBytes:   shardBytes,
+	})
+}
+
+// exportOptions フラグからformats.ExportOptionsを生成する
+func exportOptions(onProgress func(p formats.Progress)) formats.ExportOptions {
+	return formats.ExportOptions{
+		OutputImageWidth:   outputImageWidth,
+		OutputImageHeight:  outputImageHeight,
+		SkipCorruptRecords: skipCorrupt,
+		PixelScaling:       formats.PixelScaling(strings.ToLower(pixelScaling)),
+		Resize:             resizeOptions,
+		Normalize:          normalizeOptions(),
+		OnProgress:         onProgress,
+	}
+}
+
+// normalizeOptions --crop-inkなどから前処理のオプションを作成する
+func normalizeOptions() formats.NormalizeOptions {
+	return formats.NormalizeOptions{
+		CropInk:      cropInk,
+		InkMargin:    inkMargin,
+		InkThreshold: inkThreshold,
+		CenterOfMass: centerOfMass,
+	}
+}
+
+// splitList カンマ区切りの値を小文字にして分割する
+func splitList(s string) []string {
+	values := []string{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseColor 0-255のグレイスケールの値もしくは#rrggbbの色をパーズする
+func parseColor(s string) (color.Color, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		v, err := strconv.ParseUint(s[1:], 16, 32)
+		if err != nil || len(s) != 7 {
+			return nil, fmt.Errorf("invalid color: %s", s)
+		}
+		return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color: %s", s)
+	}
+	return color.Gray{Y: uint8(v)}, nil
 }
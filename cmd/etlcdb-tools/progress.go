@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PyYoshi/etlcdb-tools/formats"
+)
+
+// progressInterval 進捗を再表示する間隔
+const progressInterval = 200 * time.Millisecond
+
+// progressETAMinElapsed 残り時間を表示するまでに計測する時間. それまでは速度が安定しないため"--"と表示する
+const progressETAMinElapsed = time.Second
+
+// progressPrinter データセット作成の進捗を1行で表示する
+type progressPrinter struct {
+	mu           sync.Mutex
+	w            io.Writer
+	name         string
+	start        time.Time
+	startRecords int64
+	started      bool
+	last         time.Time
+	lastLen      int
+	latest       formats.Progress
+}
+
+// newProgressPrinter progressPrinterを生成する
+// - w: 出力先
+// - name: フォーマット名
+func newProgressPrinter(w io.Writer, name string) *progressPrinter {
+	return &progressPrinter{
+		w:    w,
+		name: name,
+	}
+}
+
+// update formats.DatasetsOptions.OnProgressへ渡す関数. progressInterval毎に表示を更新する
+func (p *progressPrinter) update(pr formats.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if !p.started {
+		// 読み飛ばしたファイルのレコードは速度の計算に含めない
+		p.started = true
+		p.start = now
+		p.startRecords = pr.RecordsParsed
+	}
+	// workerは進捗をロックの外で取得するため古い進捗が後から届くことがある. 各値は単調増加なので大きい方を残す
+	p.latest.Format = pr.Format
+	p.latest.RecordsParsed = maxInt64(p.latest.RecordsParsed, pr.RecordsParsed)
+	p.latest.ImagesWritten = maxInt64(p.latest.ImagesWritten, pr.ImagesWritten)
+	p.latest.BytesRead = maxInt64(p.latest.BytesRead, pr.BytesRead)
+	p.latest.RecordTotalNum = pr.RecordTotalNum
+	p.latest.BytesTotal = pr.BytesTotal
+
+	if now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	p.print(now)
+}
+
+// finish 最後の進捗を表示して改行する
+func (p *progressPrinter) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.started {
+		return
+	}
+	p.print(time.Now())
+	fmt.Fprintln(p.w)
+	p.lastLen = 0
+}
+
+// Write logの出力先にする. 表示中の進捗の行を消してから書き込み, 進捗を再表示する
+func (p *progressPrinter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lastLen == 0 {
+		return p.w.Write(b)
+	}
+
+	_, err := fmt.Fprintf(p.w, "\r%s\r", strings.Repeat(" ", p.lastLen))
+	if err != nil {
+		return 0, err
+	}
+	n, err := p.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	p.lastLen = 0
+	p.print(time.Now())
+	return n, nil
+}
+
+func (p *progressPrinter) print(now time.Time) {
+	pr := p.latest
+
+	line := fmt.Sprintf("%s: %d/%d records", p.name, pr.RecordsParsed, pr.RecordTotalNum)
+	if pr.RecordTotalNum > 0 {
+		line += fmt.Sprintf(" (%.1f%%)", float64(pr.RecordsParsed)*100/float64(pr.RecordTotalNum))
+	}
+	line += fmt.Sprintf(", %d images, %.1f/%.1f MiB", pr.ImagesWritten, float64(pr.BytesRead)/(1<<20), float64(pr.BytesTotal)/(1<<20))
+
+	rate := 0.0
+	elapsed := now.Sub(p.start)
+	if elapsed > 0 {
+		rate = float64(pr.RecordsParsed-p.startRecords) / elapsed.Seconds()
+	}
+	line += fmt.Sprintf(", %.1f records/s", rate)
+
+	eta := "--"
+	if elapsed >= progressETAMinElapsed && rate > 0 && pr.RecordTotalNum > pr.RecordsParsed {
+		remaining := time.Duration(float64(pr.RecordTotalNum-pr.RecordsParsed) / rate * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+	line += ", ETA " + eta
+
+	// 前回の表示より短い場合は空白で上書きする
+	pad := ""
+	if len(line) < p.lastLen {
+		pad = strings.Repeat(" ", p.lastLen-len(line))
+	}
+	p.lastLen = len(line)
+	fmt.Fprintf(p.w, "\r%s%s", line, pad)
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// isTerminal ファイルが端末かどうか
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
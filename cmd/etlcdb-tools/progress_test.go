@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PyYoshi/etlcdb-tools/formats"
+)
+
+func TestProgressPrinterKeepsMaximum(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := newProgressPrinter(buf, "ETL9G")
+
+	// 古い進捗が後から届いても各値は大きい方を残す
+	p.update(formats.Progress{RecordsParsed: 5, ImagesWritten: 4, BytesRead: 3 << 20, RecordTotalNum: 10, BytesTotal: 6 << 20})
+	p.update(formats.Progress{RecordsParsed: 3, ImagesWritten: 2, BytesRead: 1 << 20, RecordTotalNum: 10, BytesTotal: 6 << 20})
+	if p.latest.RecordsParsed != 5 || p.latest.ImagesWritten != 4 || p.latest.BytesRead != 3<<20 {
+		t.Errorf("latest = %+v, want 5 records, 4 images, %d bytes", p.latest, 3<<20)
+	}
+
+	buf.Reset()
+	p.finish()
+	out := buf.String()
+	if !strings.HasPrefix(out, "\rETL9G: 5/10 records (50.0%), 4 images, 3.0/6.0 MiB") {
+		t.Errorf("finish printed %q, want the latest progress", out)
+	}
+	if !strings.HasSuffix(out, "\n") {
+		t.Errorf("finish printed %q, want a trailing newline", out)
+	}
+}
+
+func TestProgressPrinterFinishWithoutUpdate(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := newProgressPrinter(buf, "ETL9G")
+	p.finish()
+	if buf.Len() != 0 {
+		t.Errorf("finish without updates printed %q, want nothing", buf.String())
+	}
+}
+
+func TestProgressPrinterWrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := newProgressPrinter(buf, "ETL9G")
+
+	// 進捗を表示する前のログはそのまま出力する
+	p.Write([]byte("before\n"))
+	if buf.String() != "before\n" {
+		t.Errorf("Write before progress = %q, want %q", buf.String(), "before\n")
+	}
+
+	p.update(formats.Progress{RecordsParsed: 1, RecordTotalNum: 2})
+	line := buf.String()[len("before\n"):]
+	buf.Reset()
+
+	// 進捗の行を空白で消してからログを出力し, 進捗を再表示する
+	p.Write([]byte("ETL9G: reading ETL9G_01\n"))
+	clear := "\r" + strings.Repeat(" ", len(line)-1) + "\r"
+	out := buf.String()
+	if !strings.HasPrefix(out, clear+"ETL9G: reading ETL9G_01\n\rETL9G: 1/2 records") {
+		t.Errorf("Write during progress = %q, want the line cleared, the log and the progress redrawn", out)
+	}
+
+	// 改行した後のログはそのまま出力する
+	p.finish()
+	buf.Reset()
+	p.Write([]byte("after\n"))
+	if buf.String() != "after\n" {
+		t.Errorf("Write after finish = %q, want %q", buf.String(), "after\n")
+	}
+}
+
+func TestProgressPrinterETA(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name    string
+		elapsed time.Duration
+		parsed  int64
+		total   int64
+		want    string
+	}{
+		// 3 records/sで残り68件は22.67秒. 切り捨てずに丸める
+		{"rounded", 10 * time.Second, 30, 98, "ETA 23s"},
+		// 計測した時間が短い間は残り時間を表示しない
+		{"too early", 500 * time.Millisecond, 30, 98, "ETA --"},
+		{"no records", 10 * time.Second, 0, 98, "ETA --"},
+	}
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		p := newProgressPrinter(buf, "ETL9G")
+		p.started = true
+		p.start = now.Add(-c.elapsed)
+		p.latest = formats.Progress{RecordsParsed: c.parsed, RecordTotalNum: c.total}
+		p.print(now)
+		if !strings.HasSuffix(buf.String(), c.want) {
+			t.Errorf("%s: printed %q, want suffix %q", c.name, buf.String(), c.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package formats
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// PixelScaling 元のビット深度の画素値を出力する画素値にする方法
+type PixelScaling string
+
+const (
+	// PixelScalingShift 8bitになるよう左シフトする e.g) 4bitの0xFは240. パーズした画像そのまま
+	PixelScalingShift PixelScaling = "shift"
+
+	// PixelScalingFull 8bitの全範囲へ伸ばす(v*255/最大値) e.g) 4bitの0xFは255
+	PixelScalingFull PixelScaling = "full"
+
+	// PixelScalingNative 元のビット深度の値をそのまま出力する
+	// 画像は元のビット深度の階調のパレットを持つimage.Palettedになり, PNGでは4bitや1bitのパレットで出力する.
+	// IDXや.npyなど画素の配列として出力する場合は元の値(4bitなら0-15)を格納する
+	PixelScalingNative PixelScaling = "native"
+)
+
+// checkPixelScaling 対応している画素値の変換方法かどうか確認する
+func checkPixelScaling(scaling PixelScaling) error {
+	switch scaling {
+	case "", PixelScalingShift, PixelScalingFull, PixelScalingNative:
+		return nil
+	}
+	return fmt.Errorf("unknown pixel scaling: %s", scaling)
+}
+
+// scalePixels レコードの画像の画素値を変換する
+// パーズした画像は元の値を8bitになるよう左シフトしたグレイスケールのため, 右シフトで元の値に戻してから変換する
+// - record: レコード
+// - scaling: 変換方法. 空の場合はPixelScalingShift
+func scalePixels(record Record, scaling PixelScaling) image.Image {
+	img := record.GetImage()
+	depth := record.GetBitDepth()
+	gray, ok := img.(*image.Gray)
+	if !ok || depth < 1 || depth >= 8 {
+		return img
+	}
+
+	shift := uint(8 - depth)
+	switch scaling {
+	case PixelScalingFull:
+		levels := grayLevels(depth)
+		scaled := image.NewGray(gray.Rect)
+		for i, v := range gray.Pix {
+			scaled.Pix[i] = levels[v>>shift]
+		}
+		return scaled
+	case PixelScalingNative:
+		native := image.NewPaletted(gray.Rect, grayPalette(depth))
+		for y := gray.Rect.Min.Y; y < gray.Rect.Max.Y; y++ {
+			src := gray.Pix[gray.PixOffset(gray.Rect.Min.X, y):gray.PixOffset(gray.Rect.Max.X, y)]
+			dst := native.Pix[native.PixOffset(native.Rect.Min.X, y):]
+			for i, v := range src {
+				dst[i] = v >> shift
+			}
+		}
+		return native
+	}
+	return img
+}
+
+// grayLevels 元のビット深度の値を8bitの全範囲へ伸ばした値
+func grayLevels(depth int) []uint8 {
+	max := 1<<uint(depth) - 1
+	levels := make([]uint8, max+1)
+	for v := range levels {
+		levels[v] = uint8((v*255 + max/2) / max)
+	}
+	return levels
+}
+
+// grayPalette 元のビット深度の階調のグレイスケールのパレット
+func grayPalette(depth int) color.Palette {
+	levels := grayLevels(depth)
+	palette := make(color.Palette, len(levels))
+	for i, v := range levels {
+		palette[i] = color.Gray{Y: v}
+	}
+	return palette
+}
+
+// nativeGrayPixels PixelScalingNativeで変換した画像の場合, 元の値の画素の配列と最大値を返す
+func nativeGrayPixels(img image.Image) ([]byte, int, bool) {
+	p, ok := img.(*image.Paletted)
+	if !ok || len(p.Palette) < 2 {
+		return nil, 0, false
+	}
+	levels := grayLevels(bits.Len(uint(len(p.Palette) - 1)))
+	if len(levels) != len(p.Palette) {
+		return nil, 0, false
+	}
+	for i, c := range p.Palette {
+		if g, ok := c.(color.Gray); !ok || g.Y != levels[i] {
+			return nil, 0, false
+		}
+	}
+
+	b := p.Bounds()
+	pix := make([]byte, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pix = append(pix, p.Pix[p.PixOffset(b.Min.X, y):p.PixOffset(b.Max.X, y)]...)
+	}
+	return pix, len(p.Palette) - 1, true
+}
@@ -0,0 +1,108 @@
+package formats
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func TestGrayLevels(t *testing.T) {
+	cases := []struct {
+		depth int
+		want  []uint8
+	}{
+		{1, []uint8{0, 255}},
+		{2, []uint8{0, 85, 170, 255}},
+		{4, []uint8{0, 17, 34, 51, 68, 85, 102, 119, 136, 153, 170, 187, 204, 221, 238, 255}},
+	}
+	for _, c := range cases {
+		if got := grayLevels(c.depth); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("grayLevels(%d) = %v, want %v", c.depth, got, c.want)
+		}
+	}
+}
+
+func TestScalePixels(t *testing.T) {
+	// 4bitの0, 1, 8, 15をパーズした画像(左シフト済み)
+	gray4 := &image.Gray{Pix: []uint8{0x00, 0x10, 0x80, 0xf0}, Stride: 2, Rect: image.Rect(0, 0, 2, 2)}
+	// 1bitの0, 1
+	gray1 := &image.Gray{Pix: []uint8{0x00, 0xff}, Stride: 2, Rect: image.Rect(0, 0, 2, 1)}
+
+	cases := []struct {
+		name    string
+		record  Record
+		scaling PixelScaling
+		// want 8bitグレイスケールにした画素
+		want []uint8
+		// native 配列として出力する元の値. PixelScalingNative以外はnil
+		native []uint8
+	}{
+		{"shift 4bit", &RecordMType{Image: gray4}, PixelScalingShift, []uint8{0x00, 0x10, 0x80, 0xf0}, nil},
+		{"default 4bit", &RecordMType{Image: gray4}, "", []uint8{0x00, 0x10, 0x80, 0xf0}, nil},
+		{"full 4bit", &RecordMType{Image: gray4}, PixelScalingFull, []uint8{0, 17, 136, 255}, nil},
+		{"native 4bit", &RecordMType{Image: gray4}, PixelScalingNative, []uint8{0, 17, 136, 255}, []uint8{0, 1, 8, 15}},
+		{"full 1bit", &RecordBinary{Image: gray1}, PixelScalingFull, []uint8{0, 255}, nil},
+		{"native 1bit", &RecordBinary{Image: gray1}, PixelScalingNative, []uint8{0, 255}, []uint8{0, 1}},
+	}
+	for _, c := range cases {
+		img := scalePixels(c.record, c.scaling)
+		if got := toGray(img).Pix; !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: pixels = %v, want %v", c.name, got, c.want)
+		}
+
+		pix, max, ok := nativeGrayPixels(img)
+		if ok != (c.native != nil) {
+			t.Errorf("%s: native = %v, want %v", c.name, ok, c.native != nil)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !reflect.DeepEqual(pix, c.native) {
+			t.Errorf("%s: native pixels = %v, want %v", c.name, pix, c.native)
+		}
+		if want := 1<<uint(c.record.GetBitDepth()) - 1; max != want {
+			t.Errorf("%s: max = %d, want %d", c.name, max, want)
+		}
+		if got := grayPixels(img); !reflect.DeepEqual(got, c.native) {
+			t.Errorf("%s: grayPixels = %v, want %v", c.name, got, c.native)
+		}
+	}
+}
+
+func TestNativeGrayPixelsOtherPalette(t *testing.T) {
+	// 元のビット深度の階調以外のパレットは元の値として扱わない
+	p := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Gray{Y: 0}, color.Gray{Y: 128}})
+	if _, _, ok := nativeGrayPixels(p); ok {
+		t.Error("nativeGrayPixels accepted a palette that is not gray levels")
+	}
+}
+
+func TestNativePNGBitDepth(t *testing.T) {
+	gray4 := &image.Gray{Pix: []uint8{0x00, 0x10, 0x80, 0xf0}, Stride: 2, Rect: image.Rect(0, 0, 2, 2)}
+	gray1 := &image.Gray{Pix: []uint8{0x00, 0xff}, Stride: 2, Rect: image.Rect(0, 0, 2, 1)}
+	cases := []struct {
+		name   string
+		record Record
+		depth  byte
+	}{
+		{"4bit", &RecordMType{Image: gray4}, 4},
+		{"1bit", &RecordBinary{Image: gray1}, 1},
+	}
+	for _, c := range cases {
+		buf := bytes.Buffer{}
+		err := (&pngEncoder{level: png.BestCompression}).Encode(&buf, scalePixels(c.record, PixelScalingNative))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// IHDRのビット深度とカラータイプ(3: パレット)
+		b := buf.Bytes()
+		if b[24] != c.depth || b[25] != 3 {
+			t.Errorf("%s: bit depth = %d, color type = %d, want %d, 3", c.name, b[24], b[25], c.depth)
+		}
+	}
+}
@@ -2,11 +2,12 @@ package formats
 
 import (
 	"bytes"
+	"fmt"
 	"image"
-	"image/png"
 	"io/ioutil"
 	"path"
 
+	"github.com/PyYoshi/etlcdb-tools/tables"
 	"github.com/PyYoshi/etlcdb-tools/utils"
 )
 
@@ -27,20 +28,51 @@ const (
 )
 
 type Record interface {
-	OutputImage(outputDir string, width, height int) error
 	DeallocImage()
 	GetKey() string
+	SetKey(key string)
+	GetImage() image.Image
+	GetBitDepth() int
+	GetCharacter() string
+	GetCharacterCode() uint16
 }
 
-// outputPng レコードに格納された画像をPNG形式で任意のディレクトリへ出力する
+// RecordError レコードの読み込み, パーズ, 出力で発生したエラー
+type RecordError struct {
+	// Path ETLファイルのパス. 不明な場合は空文字
+	Path string
+
+	// Index ファイル内でのレコードのインデックス. ファイル自体のエラーの場合は-1
+	Index int
+
+	// Err 発生したエラー
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	switch {
+	case e.Index < 0:
+		return fmt.Sprintf("%s: %v", e.Path, e.Err)
+	case e.Path == "":
+		return fmt.Sprintf("record %d: %v", e.Index, e.Err)
+	default:
+		return fmt.Sprintf("%s: record %d: %v", e.Path, e.Index, e.Err)
+	}
+}
+
+// Unwrap 発生したエラーを返す
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// outputImage 画像をエンコードして任意のディレクトリへ出力する
 // - outputDir: 出力するディレクトリパス
 // - imageName: 画像ファイル名
 // - img: 画像データ
-// - compLevel: 圧縮レベル
-func outputPng(outputDir, imageName string, img image.Image, compLevel png.CompressionLevel) error {
+// - enc: エンコーダ
+func outputImage(outputDir, imageName string, img image.Image, enc ImageEncoder) error {
 	buf := bytes.Buffer{}
 
-	enc := &png.Encoder{CompressionLevel: compLevel}
 	err := enc.Encode(&buf, img)
 	if err != nil {
 		return err
@@ -54,3 +86,19 @@ func outputPng(outputDir, imageName string, img image.Image, compLevel png.Compr
 	fpath := path.Join(outputDir, imageName)
 	return ioutil.WriteFile(fpath, buf.Bytes(), 0644)
 }
+
+// jis0201Character JIS X 0201の文字コードを文字にする. 対応する文字がない場合は空文字
+// ひらがなのデータセット(ETL4, ETL7)ではカタカナの文字コードをひらがなとして変換する
+// - code: JIS X 0201の文字コード
+// - format: レコードのフォーマット
+func jis0201Character(code uint8, format ETLFormat) string {
+	if format == ETLFormat4 || format == ETLFormat7 {
+		if u, ok := tables.JIS0201Hiragana[uint16(code)]; ok {
+			return string(rune(u))
+		}
+	}
+	if u, ok := tables.JIS0201[uint16(code)]; ok {
+		return string(rune(u))
+	}
+	return ""
+}
@@ -0,0 +1,766 @@
+package formats
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/PyYoshi/etlcdb-tools/utils"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// FormatSpec データセットを作成するためのフォーマットごとの情報
+type FormatSpec struct {
+	// Format フォーマット
+	Format ETLFormat
+
+	// Name フォーマット名. etlcdbのディレクトリ名, メタデータのファイル名に利用する e.g) ETL9G
+	Name string
+
+	// RecordSize レコードサイズ
+	RecordSize int
+
+	// RecordTotalNum レコード総数
+	RecordTotalNum int
+
+	// DummyRecordNum 各ファイルの先頭にあるダミーレコード数
+	DummyRecordNum int
+
+	// FileNames 入力ファイル名
+	FileNames []string
+
+	// Parse 1レコードをパーズする関数
+	Parse func(fp io.Reader) (Record, error)
+
+	// Prepare 入力ディレクトリに合わせてレコードをパーズする関数を返す. 不要な場合はnil
+	// データセット作成前などに呼び出し, 返した関数をParseの代わりに使う
+	// - inputDir: 入力ファイルがあるディレクトリパス
+	Prepare func(inputDir string) (func(fp io.Reader) (Record, error), error)
+}
+
+var (
+	formatSpecsMu sync.RWMutex
+	formatSpecs   = map[ETLFormat]*FormatSpec{}
+)
+
+// RegisterFormat フォーマットを登録する. 同じフォーマットが登録済みの場合は上書きする
+func RegisterFormat(spec *FormatSpec) {
+	formatSpecsMu.Lock()
+	defer formatSpecsMu.Unlock()
+	formatSpecs[spec.Format] = spec
+}
+
+// LookupFormat 登録済みのフォーマットの情報を取得する
+func LookupFormat(format ETLFormat) (*FormatSpec, error) {
+	formatSpecsMu.RLock()
+	defer formatSpecsMu.RUnlock()
+	spec, ok := formatSpecs[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+	return spec, nil
+}
+
+// Formats 登録済みのフォーマットの情報をフォーマット名順に返す
+func Formats() []*FormatSpec {
+	formatSpecsMu.RLock()
+	defer formatSpecsMu.RUnlock()
+	specs := make([]*FormatSpec, 0, len(formatSpecs))
+	for _, spec := range formatSpecs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+	return specs
+}
+
+// numberedFileNames 1からnまでの番号をformat(e.g. "ETL1C_%02d")へ埋め込んだファイル名を生成する
+func numberedFileNames(format string, n int) []string {
+	fileNames := make([]string, n)
+	for i := 1; i <= n; i++ {
+		fileNames[i-1] = fmt.Sprintf(format, i)
+	}
+	return fileNames
+}
+
+// checkpointKeyPrefix 処理済みの入力ファイルを記録するleveldbのキーのプレフィックス
+// レコードのキー(画像ファイル名)と衝突しないように先頭を\x00にする
+const checkpointKeyPrefix = "\x00checkpoint/"
+
+// checkpointKey 入力ファイルの処理済みを記録するleveldbのキー
+// - fileName: 入力ファイル名
+func checkpointKey(fileName string) []byte {
+	return []byte(checkpointKeyPrefix + fileName)
+}
+
+// isCheckpointKey チェックポイントのキーかどうか
+func isCheckpointKey(key []byte) bool {
+	return strings.HasPrefix(string(key), checkpointKeyPrefix)
+}
+
+// paramsKey leveldbを作成した際の出力に影響する設定を記録するキー
+// Resumeで設定の異なるleveldbを再開しないよう比較する
+var paramsKey = []byte("\x00params")
+
+// DatasetsOptions データセット作成時のオプション
+type DatasetsOptions struct {
+	// OutputImageWidth 出力する画像の幅
+	OutputImageWidth int
+
+	// OutputImageHeight 出力する画像の高さ
+	OutputImageHeight int
+
+	// WorkerNum 並行して実行する数. 画像をファイルとして出力しないSinkの場合は出力の順番を保つため1にする
+	WorkerNum int
+
+	// SkipCorruptRecords trueの場合, パーズに失敗したレコードを読み飛ばして処理を続ける
+	// 画像のエンコードやSinkへの出力に失敗した場合は読み飛ばさずに中断する
+	SkipCorruptRecords bool
+
+	// OnSkip SkipCorruptRecordsで読み飛ばしたレコードを通知する関数. nilの場合はログへ出力する
+	// 複数のworkerから同時に呼び出される可能性がある
+	OnSkip func(err *RecordError)
+
+	// OnProgress 進捗を通知する関数. nilの場合は通知しない
+	// 開始時とレコードを1件処理するごとに呼び出される. 複数のworkerから同時に呼び出される可能性がある
+	OnProgress func(p Progress)
+
+	// MetadataFormats 出力するメタデータの形式. 空の場合はMetadataFormatJSONのみ出力する
+	// 指定しなかった形式のメタデータが出力ディレクトリにある場合は削除する
+	MetadataFormats []MetadataFormat
+
+	// Layout 画像の配置. 空の場合はImageLayoutFlat
+	// ImageLayoutFlat以外の場合はディレクトリと文字の対応をclasses.jsonへ出力する. Sinkを指定した場合は無視する
+	Layout ImageLayout
+
+	// Encoding 画像の形式. 空の場合はImageEncodingPNG. 画像ファイル名の拡張子も形式に合わせる. Sinkを指定した場合は無視する
+	Encoding ImageEncoding
+
+	// PNGCompression PNGの圧縮レベル. 空の場合はPNGCompressionBest. Sinkを指定した場合は無視する
+	PNGCompression PNGCompression
+
+	// PixelScaling 画素値の変換方法. 空の場合はPixelScalingShift
+	PixelScaling PixelScaling
+
+	// Normalize リサイズ前に文字の位置を揃える前処理
+	Normalize NormalizeOptions
+
+	// Resize リサイズのフィルタと方法
+	Resize ResizeOptions
+
+	// Sink サンプルの出力先. nilの場合はNewImageSinkで出力ディレクトリへ画像を出力する
+	// 画像をファイルとして出力しないSinkの場合はマニフェストとResumeを使わず, 毎回すべての入力ファイルを順番に処理する.
+	// 指定したSinkは呼び出し側で, 成功した場合はClose, 失敗した場合はAbortすること
+	Sink OutputSink
+
+	// Resume trueの場合, 前回中断したときのleveldbを引き継ぎ処理済みの入力ファイルを読み飛ばす
+	// 前回と画像の設定が異なる場合はleveldbを破棄して最初から処理する.
+	// falseの場合はleveldbを削除して最初から処理する
+	Resume bool
+}
+
+// Progress データセット作成の進捗
+type Progress struct {
+	// Format フォーマット
+	Format ETLFormat
+
+	// RecordsParsed パーズしたレコード数. Resumeや再利用で読み飛ばしたファイルのレコードも含む
+	RecordsParsed int64
+
+	// ImagesWritten 出力した画像数. Resumeや再利用で読み飛ばしたファイルの画像も含む
+	ImagesWritten int64
+
+	// BytesRead 読み込んだ入力ファイルのバイト数
+	BytesRead int64
+
+	// RecordTotalNum フォーマットのレコード総数
+	RecordTotalNum int64
+
+	// BytesTotal 入力ファイルの合計バイト数
+	BytesTotal int64
+}
+
+// progressCounter 複数のworkerから更新される進捗
+type progressCounter struct {
+	recordsParsed int64
+	imagesWritten int64
+	bytesRead     int64
+}
+
+// add 進捗を加算する
+func (c *progressCounter) add(recordsParsed, imagesWritten, bytesRead int64) {
+	atomic.AddInt64(&c.recordsParsed, recordsParsed)
+	atomic.AddInt64(&c.imagesWritten, imagesWritten)
+	atomic.AddInt64(&c.bytesRead, bytesRead)
+}
+
+type jobWorkerMakeDatasets struct {
+	// progress 64bitアラインメントのため先頭に置く
+	progress   progressCounter
+	bytesTotal int64
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	spec      *FormatSpec
+	outputDir string
+	opts      *DatasetsOptions
+	ldb       *leveldb.DB
+	mu        *sync.Mutex
+
+	// err 最初に発生したエラー. muで保護する
+	err error
+}
+
+func (w *jobWorkerMakeDatasets) start(wg *sync.WaitGroup, q chan string) {
+	defer wg.Done()
+	for {
+		fpath, ok := <-q // closeされると ok が false になる
+		if !ok {
+			return
+		}
+
+		// キャンセルされたか他のworkerでエラーが発生している場合は残りのファイルを処理しない
+		if w.ctx.Err() != nil {
+			continue
+		}
+
+		err := w.makeDatasets(fpath)
+		if err != nil && err != w.ctx.Err() {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+
+			// 他のworkerを中断させる
+			w.cancel()
+		}
+	}
+}
+
+// reportProgress 進捗を加算してOnProgressへ通知する
+func (w *jobWorkerMakeDatasets) reportProgress(recordsParsed, imagesWritten, bytesRead int64) {
+	w.progress.add(recordsParsed, imagesWritten, bytesRead)
+	if w.opts.OnProgress == nil {
+		return
+	}
+	w.opts.OnProgress(Progress{
+		Format:         w.spec.Format,
+		RecordsParsed:  atomic.LoadInt64(&w.progress.recordsParsed),
+		ImagesWritten:  atomic.LoadInt64(&w.progress.imagesWritten),
+		BytesRead:      atomic.LoadInt64(&w.progress.bytesRead),
+		RecordTotalNum: int64(w.spec.RecordTotalNum),
+		BytesTotal:     w.bytesTotal,
+	})
+}
+
+// skip 読み飛ばしたレコードを通知する
+func (w *jobWorkerMakeDatasets) skip(err *RecordError) {
+	if w.opts.OnSkip != nil {
+		w.opts.OnSkip(err)
+		return
+	}
+	log.Printf("%s: skipped: %v\n", w.spec.Name, err)
+}
+
+// makeDatasets 1ファイル分のレコードを処理する
+// leveldbへはファイル単位で書き込むため, 途中で中断した場合そのファイルのレコードは書き込まれない
+// 書き込みと同時にチェックポイントを記録し, Resume時に読み飛ばせるようにする
+func (w *jobWorkerMakeDatasets) makeDatasets(fpath string) error {
+	log.Printf("%s: reading %s\n", w.spec.Name, fpath)
+
+	// ファイルを開く
+	f, err := os.Open(fpath)
+	if err != nil {
+		return &RecordError{Path: fpath, Index: -1, Err: err}
+	}
+	defer f.Close()
+
+	// マニフェスト用に読み込みながらハッシュ値を求める
+	h := sha256.New()
+	cr := &countReader{r: io.TeeReader(f, h)}
+	mf := &manifestFile{Images: []string{}, Classes: map[string]string{}}
+	// 同じキーのレコードはleveldbで1件になるため, マニフェストにも1回だけ記録する
+	imageSeen := map[string]bool{}
+
+	it := newRecordIterator(cr, w.spec)
+	it.path = fpath
+
+	ldbBatch := new(leveldb.Batch)
+	var bytesReported int64
+	for {
+		if !it.Next() {
+			err = it.Err()
+			if err == nil {
+				break
+			}
+			rerr, ok := err.(*RecordError)
+			if !ok {
+				return &RecordError{Path: fpath, Index: -1, Err: err}
+			}
+			if w.opts.SkipCorruptRecords {
+				w.skip(rerr)
+				continue
+			}
+			return rerr
+		}
+		record := it.Record()
+
+		rjb, err := w.outputRecord(record)
+		w.reportProgress(1, 0, cr.n-bytesReported)
+		bytesReported = cr.n
+		if err != nil {
+			return &RecordError{Path: fpath, Index: it.Index(), Err: err}
+		}
+		w.reportProgress(0, 1, 0)
+		ldbBatch.Put([]byte(record.GetKey()), rjb)
+
+		imageFile := record.GetKey()
+		if ps, ok := w.opts.Sink.(imagePathSink); ok {
+			imageFile = ps.imagePath(record.GetKey(), record)
+		}
+		if !imageSeen[imageFile] {
+			imageSeen[imageFile] = true
+			mf.Images = append(mf.Images, imageFile)
+		}
+		if dir := classDir(record, w.opts.Layout); dir != "" {
+			mf.Classes[dir] = record.GetCharacter()
+		}
+
+		// キャンセルされた場合はこのファイルのバッチを破棄して中断する
+		if err = w.ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	// レコードと同じバッチで処理済みを記録する
+	mf.SHA256 = hex.EncodeToString(h.Sum(nil))
+	mf.Size = cr.n
+	w.reportProgress(0, 0, cr.n-bytesReported)
+	cb, err := json.Marshal(mf)
+	if err != nil {
+		return &RecordError{Path: fpath, Index: -1, Err: err}
+	}
+	ldbBatch.Put(checkpointKey(path.Base(fpath)), cb)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err = w.ldb.Write(ldbBatch, nil)
+	if err != nil {
+		return &RecordError{Path: fpath, Index: -1, Err: err}
+	}
+	return nil
+}
+
+// countReader 読み込んだバイト数を数えるio.Reader
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// outputRecord レコードの画像をリサイズしてSinkへ出力し, メタデータをJSONにして返す
+func (w *jobWorkerMakeDatasets) outputRecord(record Record) ([]byte, error) {
+	if record.GetImage() == nil {
+		return nil, fmt.Errorf("%s: image is nil", record.GetKey())
+	}
+
+	// 画素値を変換し, 文字の位置を揃えてからリサイズ
+	img := scalePixels(record, w.opts.PixelScaling)
+	img = normalizeImage(img, &w.opts.Normalize)
+	img = resizeImageWith(img, w.opts.OutputImageWidth, w.opts.OutputImageHeight, &w.opts.Resize)
+
+	// メタデータの画像ファイル名を出力する画像の形式に合わせる
+	if ps, ok := w.opts.Sink.(imagePathSink); ok {
+		record.SetKey(ps.imageName(record.GetKey()))
+	}
+
+	err := w.opts.Sink.WriteSample(record.GetKey(), img, record)
+	if err != nil {
+		return nil, err
+	}
+
+	// DeallocImageを逐一呼び出ししないとメモリ不足で落ちる
+	record.DeallocImage()
+
+	return json.Marshal(record)
+}
+
+// MakeDatasets 指定ディレクトリに存在する指定フォーマットのファイルからデータセットを作成する
+// - format: フォーマット
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: データセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeDatasets(format ETLFormat, inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasetsWithOptions(format, inputDir, outputDir, DatasetsOptions{
+		OutputImageWidth:  outputImageWidth,
+		OutputImageHeight: outputImageHeight,
+		WorkerNum:         workerNum,
+	})
+}
+
+// MakeDatasetsWithOptions オプションを指定してデータセットを作成する
+// エラーが発生した場合はファイルパスとレコードのインデックスを含む*RecordErrorを返す
+// - format: フォーマット
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: データセットを出力するディレクトリパス
+// - opts: オプション
+func MakeDatasetsWithOptions(format ETLFormat, inputDir, outputDir string, opts DatasetsOptions) error {
+	return MakeDatasetsContext(context.Background(), format, inputDir, outputDir, opts)
+}
+
+// MakeDatasetsContext ctxがキャンセルされた場合に中断できるMakeDatasetsWithOptions
+// キャンセルされた場合は処理中のファイルのレコードを破棄し, leveldbを閉じてctx.Err()を返す.
+// 出力済みの画像は削除しない
+// - ctx: context
+// - format: フォーマット
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: データセットを出力するディレクトリパス
+// - opts: オプション
+func MakeDatasetsContext(ctx context.Context, format ETLFormat, inputDir, outputDir string, opts DatasetsOptions) error {
+	spec, err := LookupFormat(format)
+	if err != nil {
+		return err
+	}
+
+	if opts.WorkerNum < 1 {
+		opts.WorkerNum = 1
+	}
+
+	if opts.Layout == "" {
+		opts.Layout = ImageLayoutFlat
+	}
+	err = checkImageLayout(opts.Layout)
+	if err != nil {
+		return err
+	}
+	err = checkPixelScaling(opts.PixelScaling)
+	if err != nil {
+		return err
+	}
+	err = checkResizeOptions(&opts.Resize)
+	if err != nil {
+		return err
+	}
+	err = checkNormalizeOptions(&opts.Normalize)
+	if err != nil {
+		return err
+	}
+	if opts.Sink == nil {
+		encoder, err := NewImageEncoder(opts.Encoding, opts.PNGCompression)
+		if err != nil {
+			return err
+		}
+		opts.Sink, err = NewImageSink(outputDir, opts.Layout, encoder)
+		if err != nil {
+			return err
+		}
+	} else {
+		// 画像の配置と形式はSinkに任せる
+		opts.Layout = ImageLayoutFlat
+		opts.Encoding = ""
+		opts.PNGCompression = ""
+	}
+
+	if len(opts.MetadataFormats) == 0 {
+		opts.MetadataFormats = []MetadataFormat{MetadataFormatJSON}
+	}
+	for _, metadataFormat := range opts.MetadataFormats {
+		err = checkMetadataFormat(metadataFormat)
+		if err != nil {
+			return err
+		}
+	}
+
+	spec, err = prepareFormat(spec, inputDir)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CreateIfNotExists(outputDir, true)
+	if err != nil {
+		return err
+	}
+
+	// 画像をファイルとして出力しないSinkの場合は出力を再利用できないため, マニフェストも中断したビルドの再開も使わない
+	_, useManifest := opts.Sink.(imagePathSink)
+	if !useManifest {
+		// IDXなど1つのファイルへ書き込むSinkはサンプルの順番が出力に残るため, 入力ファイルの順番に1つのworkerで処理する
+		opts.WorkerNum = 1
+	}
+
+	ldbPath := path.Join(outputDir, ".ldb")
+	if !opts.Resume || !useManifest {
+		// 前回のleveldbが残っている場合は何が処理済みか分からないため削除する
+		err = os.RemoveAll(ldbPath)
+		if err != nil {
+			return err
+		}
+	}
+	err = utils.CreateIfNotExists(ldbPath, true)
+	if err != nil {
+		return err
+	}
+
+	ldb, err := leveldb.OpenFile(ldbPath, nil)
+	if err != nil {
+		return err
+	}
+
+	// 設定の異なるleveldbを再開すると異なる設定の画像が混ざるため, 破棄して最初から処理する
+	params := newManifestParams(&opts)
+	pb, err := json.Marshal(params)
+	if err != nil {
+		ldb.Close()
+		return err
+	}
+	if opts.Resume && useManifest {
+		prevParams, err := ldb.Get(paramsKey, nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			ldb.Close()
+			return err
+		}
+		if !bytes.Equal(prevParams, pb) {
+			log.Printf("%s: options changed since the interrupted run, restart from the beginning\n", spec.Name)
+			ldb.Close()
+			err = os.RemoveAll(ldbPath)
+			if err != nil {
+				return err
+			}
+			ldb, err = leveldb.OpenFile(ldbPath, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = ldb.Put(paramsKey, pb, nil)
+	if err != nil {
+		ldb.Close()
+		return err
+	}
+
+	// 前回から入力ファイルと設定が変わっていないファイルは出力を再利用する
+	var prev *buildManifest
+	if useManifest {
+		prev, err = readManifest(outputDir)
+		if err != nil {
+			log.Printf("%s: failed to read %s, rebuild all: %v\n", spec.Name, manifestName, err)
+			prev = nil
+		}
+	}
+	if prev != nil && prev.Format != spec.Format {
+		prev = nil
+	}
+	if prev != nil && prev.Params == params {
+		err = reusePreviousOutputs(ldb, spec, inputDir, outputDir, prev)
+		if err != nil {
+			ldb.Close()
+			return err
+		}
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobWorker := &jobWorkerMakeDatasets{
+		ctx:       workerCtx,
+		cancel:    cancel,
+		spec:      spec,
+		outputDir: outputDir,
+		opts:      &opts,
+		ldb:       ldb,
+		mu:        &sync.Mutex{},
+	}
+
+	// Resumeで処理済みのファイルや再利用したファイルは読み飛ばす
+	fpaths := []string{}
+	for _, fileName := range spec.FileNames {
+		fpath := path.Join(inputDir, fileName)
+		if fi, err := os.Stat(fpath); err == nil {
+			jobWorker.bytesTotal += fi.Size()
+		}
+
+		done, err := loadCheckpoint(ldb, fileName)
+		if err != nil {
+			ldb.Close()
+			return err
+		}
+		if done != nil {
+			jobWorker.progress.add(int64(len(done.Images)), int64(len(done.Images)), done.Size)
+			if opts.Resume {
+				log.Printf("%s: %s already done, skipped\n", spec.Name, fileName)
+			}
+			continue
+		}
+		fpaths = append(fpaths, fpath)
+	}
+	jobWorker.reportProgress(0, 0, 0)
+
+	q := make(chan string, len(fpaths))
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < opts.WorkerNum; i++ {
+		wg.Add(1)
+		go jobWorker.start(wg, q)
+	}
+
+	for _, fpath := range fpaths {
+		q <- fpath
+	}
+	close(q)
+
+	// 処理待ち
+	wg.Wait()
+	if jobWorker.err != nil {
+		ldb.Close()
+		return jobWorker.err
+	}
+	if err = ctx.Err(); err != nil {
+		ldb.Close()
+		return err
+	}
+
+	// メタデータを出力し, チェックポイントからマニフェストを作成する
+	manifest, err := writeMetadata(ldb, spec, outputDir, opts.MetadataFormats)
+	if err != nil {
+		ldb.Close()
+		return err
+	}
+	manifest.Params = params
+	manifest.Metadata = reusableMetadataFormat(opts.MetadataFormats)
+	err = removeUnusedMetadata(outputDir, spec.Name, opts.MetadataFormats)
+	if err != nil {
+		ldb.Close()
+		return err
+	}
+
+	// ディレクトリと文字の対応を出力する
+	if opts.Layout != ImageLayoutFlat {
+		classes := map[string]string{}
+		for _, mf := range manifest.Files {
+			for dir, character := range mf.Classes {
+				classes[dir] = character
+			}
+		}
+		err = writeClassIndex(outputDir, classes)
+		if err != nil {
+			ldb.Close()
+			return err
+		}
+	} else if useManifest {
+		err = os.Remove(path.Join(outputDir, classIndexName))
+		if err != nil && !os.IsNotExist(err) {
+			ldb.Close()
+			return err
+		}
+	}
+
+	// 前回出力したが今回出力しなかった画像を削除し, マニフェストを更新する
+	if prev != nil {
+		err = removeStaleImages(outputDir, prev, manifest)
+		if err != nil {
+			ldb.Close()
+			return err
+		}
+	}
+	if useManifest {
+		err = manifest.write(outputDir)
+		if err != nil {
+			ldb.Close()
+			return err
+		}
+	}
+
+	// leveldbで利用したファイルを削除
+	ldb.Close()
+	return os.RemoveAll(ldbPath)
+}
+
+// writeMetadata leveldbに書き込んだレコードをキー順にメタデータとして出力し,
+// チェックポイントから入力ファイルごとの情報を集めたマニフェストを返す
+// - ldb: leveldb
+// - spec: フォーマットの情報
+// - outputDir: データセットを出力するディレクトリパス
+// - metadataFormats: 出力するメタデータの形式
+func writeMetadata(ldb *leveldb.DB, spec *FormatSpec, outputDir string, metadataFormats []MetadataFormat) (*buildManifest, error) {
+	writers := make([]metadataWriter, 0, len(metadataFormats))
+	closeWriters := func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}
+	for _, metadataFormat := range metadataFormats {
+		w, err := newMetadataWriter(outputDir, spec.Name, metadataFormat)
+		if err != nil {
+			closeWriters()
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+
+	manifest := &buildManifest{
+		Format: spec.Format,
+		Files:  map[string]*manifestFile{},
+	}
+
+	ldbIter := ldb.NewIterator(nil, nil)
+	defer ldbIter.Release()
+	for ldbIter.Next() {
+		if bytes.Equal(ldbIter.Key(), paramsKey) {
+			continue
+		}
+		if isCheckpointKey(ldbIter.Key()) {
+			mf := &manifestFile{}
+			err := json.Unmarshal(ldbIter.Value(), mf)
+			if err != nil {
+				closeWriters()
+				return nil, err
+			}
+			manifest.Files[strings.TrimPrefix(string(ldbIter.Key()), checkpointKeyPrefix)] = mf
+			continue
+		}
+
+		for _, w := range writers {
+			err := w.WriteRecord(ldbIter.Value())
+			if err != nil {
+				closeWriters()
+				return nil, err
+			}
+		}
+	}
+	err := ldbIter.Error()
+	if err != nil {
+		closeWriters()
+		return nil, err
+	}
+
+	for _, w := range writers {
+		cerr := w.Close()
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
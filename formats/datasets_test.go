@@ -0,0 +1,398 @@
+package formats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// testFormat テスト用のフォーマット. ETL7と同じM-typeのレコードを2ファイルから読み込む
+const testFormat ETLFormat = "test"
+
+// testRecordNum テスト用の入力ファイル1つあたりのレコード数
+const testRecordNum = 3
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         testFormat,
+		Name:           "TEST",
+		RecordSize:     mTypeRecordSize,
+		RecordTotalNum: 2 * testRecordNum,
+		FileNames:      []string{"TEST_1", "TEST_2"},
+		Parse:          ParseETL7Record,
+	})
+}
+
+// mTypeTestRecord テスト用のM-typeのレコードを生成する
+// 画像はseed番目の画素のみ0xfにして, seedごとに異なる画像(画像ファイル名)にする
+// - jisCode: JIS Code
+// - seed: 画像を決める値
+func mTypeTestRecord(jisCode uint8, seed int) []byte {
+	b := make([]byte, mTypeRecordSize)
+	b[6] = jisCode
+	b[32+seed/2] = 0xf0 >> uint(seed%2*4)
+	return b
+}
+
+// writeTestFile テスト用の入力ファイルを作成する. i番目のレコードの文字は'A'+i
+// - dir: 入力ファイルを作成するディレクトリパス
+// - name: 入力ファイル名
+// - seed: 1件目のレコードの画像を決める値. 2件目以降は1ずつ増やす
+func writeTestFile(t *testing.T, dir, name string, seed int) {
+	t.Helper()
+	b := []byte{}
+	for i := 0; i < testRecordNum; i++ {
+		b = append(b, mTypeTestRecord(uint8('A'+i), seed+i)...)
+	}
+	err := ioutil.WriteFile(path.Join(dir, name), b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestInput テスト用フォーマットのすべての入力ファイルを作成した一時ディレクトリを返す
+func writeTestInput(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "etlcdb-input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "TEST_1", 0)
+	writeTestFile(t, dir, "TEST_2", testRecordNum)
+	return dir
+}
+
+// readJSONMetadata JSONのメタデータのレコードを読み込む
+func readJSONMetadata(t *testing.T, fpath string) []map[string]interface{} {
+	t.Helper()
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []map[string]interface{}{}
+	err = json.Unmarshal(b, &records)
+	if err != nil {
+		t.Fatalf("%s: %v", fpath, err)
+	}
+	return records
+}
+
+func TestMakeDatasetsResume(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    DatasetsOptions
+		wantErr bool
+	}{
+		// 処理済みのTEST_1を読み飛ばすため, 壊れたTEST_1を読み込まない
+		{"resume", DatasetsOptions{Resume: true}, false},
+		// leveldbを削除して最初からTEST_1を読み込む
+		{"no resume", DatasetsOptions{}, true},
+		// 設定が異なるためleveldbを破棄して最初からTEST_1を読み込む
+		{"options changed", DatasetsOptions{OutputImageWidth: 32, OutputImageHeight: 32, Resume: true}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			inputDir := writeTestInput(t)
+			defer os.RemoveAll(inputDir)
+			outputDir, err := ioutil.TempDir("", "etlcdb-output")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(outputDir)
+
+			// TEST_2の末尾のレコードが欠けているため, TEST_1のみ処理済みにして中断する
+			input2 := path.Join(inputDir, "TEST_2")
+			b, err := ioutil.ReadFile(input2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(input2, b[:len(b)-1], 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{})
+			if err == nil {
+				t.Fatal("MakeDatasetsWithOptions with a truncated file succeeded")
+			}
+
+			// TEST_2を直し, 処理済みのTEST_1を壊す
+			err = ioutil.WriteFile(input2, b, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(path.Join(inputDir, "TEST_1"), []byte{0}, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, c.opts)
+			if c.wantErr {
+				if rerr, ok := err.(*RecordError); !ok || path.Base(rerr.Path) != "TEST_1" {
+					t.Fatalf("err = %v, want an error reading TEST_1", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			records := readJSONMetadata(t, path.Join(outputDir, "test.json"))
+			if len(records) != 2*testRecordNum {
+				t.Errorf("%d records in metadata, want %d", len(records), 2*testRecordNum)
+			}
+			if _, err := os.Stat(path.Join(outputDir, ".ldb")); !os.IsNotExist(err) {
+				t.Errorf(".ldb remains after the build: %v", err)
+			}
+		})
+	}
+}
+
+// readOutputFiles 出力ディレクトリ内のすべてのファイルの相対パスと内容を読み込む
+func readOutputFiles(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files := map[string][]byte{}
+	err := filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		files[rel] = b
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestMakeDatasetsContextCancel(t *testing.T) {
+	inputDir := writeTestInput(t)
+	defer os.RemoveAll(inputDir)
+	outputDir, err := ioutil.TempDir("", "etlcdb-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+	wantDir, err := ioutil.TempDir("", "etlcdb-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wantDir)
+
+	// TEST_1を処理し終え, TEST_2の1件目を処理したところでキャンセルする
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := DatasetsOptions{
+		WorkerNum: 1,
+		OnProgress: func(p Progress) {
+			if p.RecordsParsed == testRecordNum+1 {
+				cancel()
+			}
+		},
+	}
+	err = MakeDatasetsContext(ctx, testFormat, inputDir, outputDir, opts)
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+
+	// 処理中だったTEST_2のレコードとチェックポイントは書き込まない
+	ldb, err := leveldb.OpenFile(path.Join(outputDir, ".ldb"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		fileName string
+		done     bool
+	}{
+		{"TEST_1", true},
+		{"TEST_2", false},
+	} {
+		done, err := loadCheckpoint(ldb, c.fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (done != nil) != c.done {
+			t.Errorf("%s: checkpoint = %v, want done = %v", c.fileName, done, c.done)
+		}
+	}
+	recordNum := 0
+	ldbIter := ldb.NewIterator(nil, nil)
+	for ldbIter.Next() {
+		if !bytes.Equal(ldbIter.Key(), paramsKey) && !isCheckpointKey(ldbIter.Key()) {
+			recordNum++
+		}
+	}
+	ldbIter.Release()
+	ldb.Close()
+	if recordNum != testRecordNum {
+		t.Errorf("%d records in leveldb, want %d", recordNum, testRecordNum)
+	}
+	if _, err := os.Stat(path.Join(outputDir, "test.json")); !os.IsNotExist(err) {
+		t.Errorf("metadata was written for the cancelled build: %v", err)
+	}
+
+	// 再開した結果は中断せずに作成した場合と一致する
+	err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{Resume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MakeDatasetsWithOptions(testFormat, inputDir, wantDir, DatasetsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readOutputFiles(t, outputDir)
+	want := readOutputFiles(t, wantDir)
+	if len(got) != len(want) {
+		t.Errorf("%d files in the resumed output, want %d", len(got), len(want))
+	}
+	for name, b := range want {
+		if !bytes.Equal(got[name], b) {
+			t.Errorf("%s differs from the uninterrupted build", name)
+		}
+	}
+}
+
+func TestMakeDatasetsProgress(t *testing.T) {
+	inputDir := writeTestInput(t)
+	defer os.RemoveAll(inputDir)
+	outputDir, err := ioutil.TempDir("", "etlcdb-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	bytesTotal := int64(2 * testRecordNum * mTypeRecordSize)
+	for _, name := range []string{"build", "reuse"} {
+		mu := sync.Mutex{}
+		progress := []Progress{}
+		opts := DatasetsOptions{
+			WorkerNum: 2,
+			OnProgress: func(p Progress) {
+				mu.Lock()
+				defer mu.Unlock()
+				progress = append(progress, p)
+			},
+		}
+		err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 各値は総数を超えず, 最大値は総数になる
+		max := Progress{}
+		for _, p := range progress {
+			if p.Format != testFormat || p.RecordTotalNum != 2*testRecordNum || p.BytesTotal != bytesTotal {
+				t.Fatalf("%s: progress = %+v, want format %s, %d records, %d bytes in total", name, p, testFormat, 2*testRecordNum, bytesTotal)
+			}
+			if p.RecordsParsed > p.RecordTotalNum || p.ImagesWritten > p.RecordsParsed || p.BytesRead > p.BytesTotal {
+				t.Errorf("%s: progress = %+v exceeds the totals", name, p)
+			}
+			max.RecordsParsed = maxProgress(max.RecordsParsed, p.RecordsParsed)
+			max.ImagesWritten = maxProgress(max.ImagesWritten, p.ImagesWritten)
+			max.BytesRead = maxProgress(max.BytesRead, p.BytesRead)
+		}
+		if max.RecordsParsed != 2*testRecordNum || max.ImagesWritten != 2*testRecordNum || max.BytesRead != bytesTotal {
+			t.Errorf("%s: maximum progress = %+v, want %d records, %d images, %d bytes", name, max, 2*testRecordNum, 2*testRecordNum, bytesTotal)
+		}
+
+		// 初回は開始時に0件を通知し, 再利用した場合は再利用したファイルの分を含めて通知する
+		want := int64(0)
+		if name == "reuse" {
+			want = 2 * testRecordNum
+			if len(progress) != 1 {
+				t.Errorf("%s: %d progress updates, want 1", name, len(progress))
+			}
+		}
+		if len(progress) == 0 || progress[0].RecordsParsed != want {
+			t.Errorf("%s: first progress = %+v, want %d records", name, progress, want)
+		}
+	}
+}
+
+func maxProgress(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// keySink WriteSampleで受け取ったキーを順番に記録するOutputSink
+type keySink struct {
+	mu   sync.Mutex
+	keys []string
+
+	// slowKey 出力に時間がかかるサンプルのキー. 並行に呼び出された場合は他のサンプルが先に記録される
+	slowKey string
+}
+
+func (s *keySink) WriteSample(key string, img image.Image, metadata Record) error {
+	if key == s.slowKey {
+		time.Sleep(50 * time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *keySink) Close() error {
+	return nil
+}
+
+func (s *keySink) Abort() error {
+	return nil
+}
+
+func TestMakeDatasetsSinkOrder(t *testing.T) {
+	inputDir := writeTestInput(t)
+	defer os.RemoveAll(inputDir)
+	outputDir, err := ioutil.TempDir("", "etlcdb-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	want := []string{}
+	for _, name := range []string{"TEST_1", "TEST_2"} {
+		it, err := OpenETLFile(path.Join(inputDir, name), testFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it.Next() {
+			want = append(want, it.Record().GetKey())
+		}
+		it.Close()
+	}
+
+	// 画像をファイルとして出力しないSinkはWorkerNumに関わらず入力ファイルの順番に受け取る
+	sink := &keySink{slowKey: want[0]}
+	err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{WorkerNum: 4, Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.keys) != len(want) {
+		t.Fatalf("sink received %d samples, want %d", len(sink.keys), len(want))
+	}
+	for i, key := range sink.keys {
+		if key != want[i] {
+			t.Errorf("sample %d = %s, want %s", i, key, want[i])
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// ImageEncoding 出力する画像の形式
+type ImageEncoding string
+
+const (
+	// ImageEncodingPNG PNG
+	ImageEncodingPNG ImageEncoding = "png"
+
+	// ImageEncodingWebP 可逆圧縮のWebP
+	ImageEncodingWebP ImageEncoding = "webp"
+
+	// ImageEncodingBMP BMP
+	ImageEncodingBMP ImageEncoding = "bmp"
+
+	// ImageEncodingTIFF Deflateで圧縮したTIFF
+	ImageEncodingTIFF ImageEncoding = "tiff"
+
+	// ImageEncodingPGM バイナリ形式のPGM(P5). PixelScalingNativeの場合は元のビット深度の最大値で出力する
+	ImageEncodingPGM ImageEncoding = "pgm"
+
+	// ImageEncodingPBM バイナリ形式のPBM(P4). 輝度が128未満の画素を黒にする
+	ImageEncodingPBM ImageEncoding = "pbm"
+)
+
+// PNGCompression PNGの圧縮レベル
+type PNGCompression string
+
+const (
+	// PNGCompressionBest 最も小さく圧縮する. 最も遅い
+	PNGCompressionBest PNGCompression = "best"
+
+	// PNGCompressionDefault 標準の圧縮レベル
+	PNGCompressionDefault PNGCompression = "default"
+
+	// PNGCompressionSpeed 速度を優先する
+	PNGCompressionSpeed PNGCompression = "speed"
+
+	// PNGCompressionNone 圧縮しない
+	PNGCompressionNone PNGCompression = "none"
+)
+
+// ImageEncoder 画像のエンコーダ
+type ImageEncoder interface {
+	// Encode 画像をエンコードしてwへ書き込む
+	Encode(w io.Writer, img image.Image) error
+
+	// Ext 出力するファイルの拡張子 e.g) .png
+	Ext() string
+}
+
+// NewImageEncoder 画像の形式に対応するImageEncoderを生成する
+// - encoding: 画像の形式. 空の場合はImageEncodingPNG
+// - pngCompression: PNGの圧縮レベル. 空の場合はPNGCompressionBest. PNG以外では無視する
+func NewImageEncoder(encoding ImageEncoding, pngCompression PNGCompression) (ImageEncoder, error) {
+	switch encoding {
+	case "", ImageEncodingPNG:
+		level, err := pngCompressionLevel(pngCompression)
+		if err != nil {
+			return nil, err
+		}
+		return &pngEncoder{level: level}, nil
+	case ImageEncodingWebP:
+		return webpEncoder{}, nil
+	case ImageEncodingBMP:
+		return bmpEncoder{}, nil
+	case ImageEncodingTIFF:
+		return tiffEncoder{}, nil
+	case ImageEncodingPGM:
+		return pnmEncoder{bitmap: false}, nil
+	case ImageEncodingPBM:
+		return pnmEncoder{bitmap: true}, nil
+	}
+	return nil, fmt.Errorf("unknown image encoding: %s", encoding)
+}
+
+// pngCompressionLevel PNGの圧縮レベルをimage/pngの値にする
+func pngCompressionLevel(compression PNGCompression) (png.CompressionLevel, error) {
+	switch compression {
+	case "", PNGCompressionBest:
+		return png.BestCompression, nil
+	case PNGCompressionDefault:
+		return png.DefaultCompression, nil
+	case PNGCompressionSpeed:
+		return png.BestSpeed, nil
+	case PNGCompressionNone:
+		return png.NoCompression, nil
+	}
+	return 0, fmt.Errorf("unknown png compression: %s", compression)
+}
+
+// pngEncoder PNGでエンコードするImageEncoder
+type pngEncoder struct {
+	level png.CompressionLevel
+}
+
+func (e *pngEncoder) Ext() string {
+	return ".png"
+}
+
+func (e *pngEncoder) Encode(w io.Writer, img image.Image) error {
+	enc := &png.Encoder{CompressionLevel: e.level}
+	return enc.Encode(w, img)
+}
+
+// webpEncoder 可逆圧縮(VP8L)のWebPでエンコードするImageEncoder
+type webpEncoder struct{}
+
+func (webpEncoder) Ext() string {
+	return ".webp"
+}
+
+func (webpEncoder) Encode(w io.Writer, img image.Image) error {
+	// nativewebp.Encodeはwへの書き込みのエラーを返さないため, バッファへエンコードしてから書き込む
+	buf := bytes.Buffer{}
+	err := nativewebp.Encode(&buf, img, nil)
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// bmpEncoder BMPでエンコードするImageEncoder
+type bmpEncoder struct{}
+
+func (bmpEncoder) Ext() string {
+	return ".bmp"
+}
+
+func (bmpEncoder) Encode(w io.Writer, img image.Image) error {
+	return bmp.Encode(w, img)
+}
+
+// tiffEncoder Deflateで圧縮したTIFFでエンコードするImageEncoder
+type tiffEncoder struct{}
+
+func (tiffEncoder) Ext() string {
+	return ".tiff"
+}
+
+func (tiffEncoder) Encode(w io.Writer, img image.Image) error {
+	return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+}
+
+// pnmEncoder バイナリ形式のPGMもしくはPBMでエンコードするImageEncoder
+type pnmEncoder struct {
+	// bitmap trueの場合はPBM
+	bitmap bool
+}
+
+func (e pnmEncoder) Ext() string {
+	if e.bitmap {
+		return ".pbm"
+	}
+	return ".pgm"
+}
+
+func (e pnmEncoder) Encode(w io.Writer, img image.Image) error {
+	var err error
+
+	gray := toGray(img)
+	width, height := gray.Rect.Dx(), gray.Rect.Dy()
+
+	bw := bufio.NewWriter(w)
+	if !e.bitmap {
+		// PixelScalingNativeで変換した画像の場合は元のビット深度の最大値で出力する
+		pix, max := gray.Pix, 255
+		if native, nativeMax, ok := nativeGrayPixels(img); ok {
+			pix, max = native, nativeMax
+		}
+		_, err = fmt.Fprintf(bw, "P5\n%d %d\n%d\n", width, height, max)
+		if err != nil {
+			return err
+		}
+		_, err = bw.Write(pix)
+		if err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	_, err = fmt.Fprintf(bw, "P4\n%d %d\n", width, height)
+	if err != nil {
+		return err
+	}
+
+	// 1行ごとに8画素を1バイトへ詰める. PBMでは1が黒
+	row := make([]byte, (width+7)/8)
+	for y := 0; y < height; y++ {
+		for i := range row {
+			row[i] = 0
+		}
+		for x, v := range gray.Pix[y*gray.Stride : y*gray.Stride+width] {
+			if v < 128 {
+				row[x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+		_, err = bw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,180 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// encodeImage 画像の形式を指定してエンコードする
+func encodeImage(t *testing.T, encoding ImageEncoding, img image.Image) []byte {
+	t.Helper()
+	enc, err := NewImageEncoder(encoding, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	err = enc.Encode(&buf, img)
+	if err != nil {
+		t.Fatalf("%s: %v", encoding, err)
+	}
+	return buf.Bytes()
+}
+
+func TestPGMEncoder(t *testing.T) {
+	gray := &image.Gray{Pix: []uint8{0x00, 0x7f, 0xff, 0x10, 0x20, 0x30}, Stride: 3, Rect: image.Rect(0, 0, 3, 2)}
+	// 4bitの0, 1, 8, 15と1bitの0, 1をパーズした画像(左シフト済み)
+	gray4 := &image.Gray{Pix: []uint8{0x00, 0x10, 0x80, 0xf0}, Stride: 2, Rect: image.Rect(0, 0, 2, 2)}
+	gray1 := &image.Gray{Pix: []uint8{0x00, 0xff}, Stride: 2, Rect: image.Rect(0, 0, 2, 1)}
+
+	cases := []struct {
+		name string
+		img  image.Image
+		want []byte
+	}{
+		{"8bit", gray, append([]byte("P5\n3 2\n255\n"), 0x00, 0x7f, 0xff, 0x10, 0x20, 0x30)},
+		// 左上が原点でない画像も原点からの画素として出力する
+		{"sub image", gray.SubImage(image.Rect(1, 0, 3, 2)), append([]byte("P5\n2 2\n255\n"), 0x7f, 0xff, 0x20, 0x30)},
+		// PixelScalingNativeの場合は元の値と最大値で出力する
+		{"native 4bit", scalePixels(&RecordMType{Image: gray4}, PixelScalingNative), append([]byte("P5\n2 2\n15\n"), 0, 1, 8, 15)},
+		{"native 1bit", scalePixels(&RecordBinary{Image: gray1}, PixelScalingNative), append([]byte("P5\n2 1\n1\n"), 0, 1)},
+		{"full 4bit", scalePixels(&RecordMType{Image: gray4}, PixelScalingFull), append([]byte("P5\n2 2\n255\n"), 0, 17, 136, 255)},
+	}
+	for _, c := range cases {
+		if got := encodeImage(t, ImageEncodingPGM, c.img); !bytes.Equal(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPBMEncoder(t *testing.T) {
+	// 幅10の画像は1行2バイトで, 2バイト目の下位6bitは0で埋める. 輝度が128未満の画素が黒(1)
+	gray := &image.Gray{
+		Pix: []uint8{
+			0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x80,
+			0xff, 0xff, 0x00, 0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00,
+		},
+		Stride: 10,
+		Rect:   image.Rect(0, 0, 10, 2),
+	}
+	// 4bitの0, 8, 15をパーズした画像のPixelScalingNative. 8bitにした136以上が白
+	gray4 := &image.Gray{Pix: []uint8{0x00, 0x80, 0xf0}, Stride: 3, Rect: image.Rect(0, 0, 3, 1)}
+
+	cases := []struct {
+		name string
+		img  image.Image
+		want []byte
+	}{
+		{"10x2", gray, append([]byte("P4\n10 2\n"), 0x80, 0x80, 0x30, 0x40)},
+		{"8x1", gray.SubImage(image.Rect(2, 1, 10, 2)), append([]byte("P4\n8 1\n"), 0xc1)},
+		{"native 4bit", scalePixels(&RecordMType{Image: gray4}, PixelScalingNative), append([]byte("P4\n3 1\n"), 0x80)},
+	}
+	for _, c := range cases {
+		if got := encodeImage(t, ImageEncodingPBM, c.img); !bytes.Equal(got, c.want) {
+			t.Errorf("%s: got % x, want % x", c.name, got, c.want)
+		}
+	}
+}
+
+// checkDecodedImage デコードした画像が元の画像と同じ大きさ, 同じ輝度であることを確認する
+func checkDecodedImage(t *testing.T, name string, decoded, img image.Image) {
+	t.Helper()
+	if decoded.Bounds().Size() != img.Bounds().Size() {
+		t.Fatalf("%s: size = %v, want %v", name, decoded.Bounds().Size(), img.Bounds().Size())
+	}
+	db := decoded.Bounds()
+	b := img.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			got := color.GrayModel.Convert(decoded.At(db.Min.X+x, db.Min.Y+y)).(color.Gray)
+			want := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray)
+			if got != want {
+				t.Fatalf("%s: pixel (%d, %d) = %d, want %d", name, x, y, got.Y, want.Y)
+			}
+		}
+	}
+}
+
+func TestLosslessEncoderRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	gray := image.NewGray(image.Rect(0, 0, 13, 7))
+	rnd.Read(gray.Pix)
+	// 4bitの値をパーズした画像のPixelScalingNative
+	gray4 := image.NewGray(image.Rect(0, 0, 9, 5))
+	for i := range gray4.Pix {
+		gray4.Pix[i] = uint8(rnd.Intn(16)) << 4
+	}
+	native := scalePixels(&RecordMType{Image: gray4}, PixelScalingNative)
+
+	decoders := []struct {
+		encoding ImageEncoding
+		ext      string
+		decode   func(r io.Reader) (image.Image, error)
+	}{
+		{ImageEncodingBMP, ".bmp", bmp.Decode},
+		{ImageEncodingTIFF, ".tiff", tiff.Decode},
+		{ImageEncodingWebP, ".webp", webp.Decode},
+	}
+	for _, d := range decoders {
+		enc, err := NewImageEncoder(d.encoding, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc.Ext() != d.ext {
+			t.Errorf("%s: Ext = %q, want %q", d.encoding, enc.Ext(), d.ext)
+		}
+
+		for _, img := range []image.Image{gray, native} {
+			decoded, err := d.decode(bytes.NewReader(encodeImage(t, d.encoding, img)))
+			if err != nil {
+				t.Fatalf("%s: decode: %v", d.encoding, err)
+			}
+			checkDecodedImage(t, fmt.Sprintf("%s %T", d.encoding, img), decoded, img)
+		}
+	}
+}
+
+func TestWebPEncoderEdgeCases(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	// 幅と高さの上限(16384)の細長い画像
+	wide := image.NewGray(image.Rect(0, 0, 1<<14, 2))
+	rnd.Read(wide.Pix)
+	tall := image.NewGray(image.Rect(0, 0, 2, 1<<14))
+	rnd.Read(tall.Pix)
+
+	cases := []struct {
+		name string
+		img  image.Image
+	}{
+		{"1x1", uniformImage(1, 1, 255)},
+		{"uniform", uniformImage(128, 127, 128)},
+		{"max width", wide},
+		{"max height", tall},
+		// 左上が原点でない画像
+		{"sub image", wide.SubImage(image.Rect(100, 1, 200, 2))},
+	}
+	for _, c := range cases {
+		decoded, err := webp.Decode(bytes.NewReader(encodeImage(t, ImageEncodingWebP, c.img)))
+		if err != nil {
+			t.Fatalf("%s: decode: %v", c.name, err)
+		}
+		checkDecodedImage(t, c.name, decoded, c.img)
+	}
+
+	// 上限を超える大きさと空の画像はエンコードできない
+	for _, size := range []image.Point{{0, 0}, {1<<14 + 1, 1}, {1, 1<<14 + 1}} {
+		img := image.NewGray(image.Rect(0, 0, size.X, size.Y))
+		if err := (webpEncoder{}).Encode(ioutil.Discard, img); err == nil {
+			t.Errorf("%dx%d: Encode = nil, want an error", size.X, size.Y)
+		}
+	}
+}
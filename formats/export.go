@@ -0,0 +1,405 @@
+package formats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ExportOptions データセットをIDXなど1つの形式へまとめて出力する際のオプション
+type ExportOptions struct {
+	// OutputImageWidth 出力する画像の幅
+	OutputImageWidth int
+
+	// OutputImageHeight 出力する画像の高さ
+	OutputImageHeight int
+
+	// SkipCorruptRecords trueの場合, パーズに失敗したレコードを読み飛ばして処理を続ける
+	SkipCorruptRecords bool
+
+	// OnSkip SkipCorruptRecordsで読み飛ばしたレコードを通知する関数. nilの場合はログへ出力する
+	OnSkip func(err *RecordError)
+
+	// OnProgress 進捗を通知する関数. nilの場合は通知しない
+	OnProgress func(p Progress)
+
+	// PixelScaling 画素値の変換方法. 空の場合はPixelScalingShift
+	PixelScaling PixelScaling
+
+	// Normalize リサイズ前に文字の位置を揃える前処理
+	Normalize NormalizeOptions
+
+	// Resize リサイズのフィルタと方法
+	Resize ResizeOptions
+}
+
+// recordExporter 入力ファイルをフォーマットで定められた順番に読み込み, レコードを1件ずつ出力する
+type recordExporter struct {
+	ctx      context.Context
+	spec     *FormatSpec
+	opts     *ExportOptions
+	progress Progress
+
+	// sink リサイズした画像とレコードの出力先
+	sink OutputSink
+}
+
+// exportRecords 入力ファイルのレコードを順番にリサイズしてsinkへ渡し, 最後にsinkをCloseする
+// sinkで発生したエラーはSkipCorruptRecordsに関わらず処理を中断する.
+// キャンセルやエラーで中断した場合はsinkをAbortして出力を破棄する
+// - ctx: context
+// - spec: フォーマットの情報
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - opts: オプション
+// - sink: リサイズした画像とレコードの出力先
+func exportRecords(ctx context.Context, spec *FormatSpec, inputDir string, opts *ExportOptions, sink OutputSink) error {
+	err := writeRecords(ctx, spec, inputDir, opts, sink)
+	if err != nil {
+		sink.Abort()
+		return err
+	}
+	return sink.Close()
+}
+
+func writeRecords(ctx context.Context, spec *FormatSpec, inputDir string, opts *ExportOptions, sink OutputSink) error {
+	var err error
+
+	err = checkPixelScaling(opts.PixelScaling)
+	if err != nil {
+		return err
+	}
+	err = checkResizeOptions(&opts.Resize)
+	if err != nil {
+		return err
+	}
+	err = checkNormalizeOptions(&opts.Normalize)
+	if err != nil {
+		return err
+	}
+
+	spec, err = prepareFormat(spec, inputDir)
+	if err != nil {
+		return err
+	}
+
+	e := &recordExporter{
+		ctx:  ctx,
+		spec: spec,
+		opts: opts,
+		progress: Progress{
+			Format:         spec.Format,
+			RecordTotalNum: int64(spec.RecordTotalNum),
+		},
+		sink: sink,
+	}
+	for _, fileName := range spec.FileNames {
+		if fi, err := os.Stat(path.Join(inputDir, fileName)); err == nil {
+			e.progress.BytesTotal += fi.Size()
+		}
+	}
+	e.reportProgress()
+
+	for _, fileName := range spec.FileNames {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		err = e.exportFile(path.Join(inputDir, fileName))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportProgress OnProgressへ進捗を通知する
+func (e *recordExporter) reportProgress() {
+	if e.opts.OnProgress != nil {
+		e.opts.OnProgress(e.progress)
+	}
+}
+
+// skip 読み飛ばしたレコードを通知する
+func (e *recordExporter) skip(err *RecordError) {
+	if e.opts.OnSkip != nil {
+		e.opts.OnSkip(err)
+		return
+	}
+	log.Printf("%s: skipped: %v\n", e.spec.Name, err)
+}
+
+// exportFile 1ファイル分のレコードを出力する
+func (e *recordExporter) exportFile(fpath string) error {
+	log.Printf("%s: reading %s\n", e.spec.Name, fpath)
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return &RecordError{Path: fpath, Index: -1, Err: err}
+	}
+	defer f.Close()
+
+	cr := &countReader{r: f}
+	it := newRecordIterator(cr, e.spec)
+	it.path = fpath
+
+	var bytesReported int64
+	for {
+		if !it.Next() {
+			err = it.Err()
+			if err == nil {
+				break
+			}
+			rerr, ok := err.(*RecordError)
+			if !ok {
+				return &RecordError{Path: fpath, Index: -1, Err: err}
+			}
+			if e.opts.SkipCorruptRecords {
+				e.skip(rerr)
+				continue
+			}
+			return rerr
+		}
+		record := it.Record()
+
+		e.progress.RecordsParsed++
+		e.progress.BytesRead += cr.n - bytesReported
+		bytesReported = cr.n
+
+		img := scalePixels(record, e.opts.PixelScaling)
+		img = normalizeImage(img, &e.opts.Normalize)
+		img = resizeImageWith(img, e.opts.OutputImageWidth, e.opts.OutputImageHeight, &e.opts.Resize)
+		err = e.sink.WriteSample(record.GetKey(), img, record)
+		if err != nil {
+			return &RecordError{Path: fpath, Index: it.Index(), Err: err}
+		}
+		record.DeallocImage()
+
+		e.progress.ImagesWritten++
+		e.reportProgress()
+
+		err = e.ctx.Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	e.progress.BytesRead += cr.n - bytesReported
+	e.reportProgress()
+	return nil
+}
+
+// toGray 画像を原点から始まる8bitグレイスケールの画像にする
+func toGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	if g, ok := img.(*image.Gray); ok && b.Min == (image.Point{}) && g.Stride == b.Dx() {
+		return g
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, b.Min, draw.Src)
+	return gray
+}
+
+// grayPixels 画像を8bitグレイスケールの画素の配列にする
+// PixelScalingNativeで変換した画像の場合は元の値の配列にする
+func grayPixels(img image.Image) []byte {
+	if pix, _, ok := nativeGrayPixels(img); ok {
+		return pix
+	}
+	gray := toGray(img)
+	return gray.Pix
+}
+
+// grayImage 画像を8bitグレイスケールにする. PixelScalingNativeで変換した画像の場合はパレットのまま返す
+func grayImage(img image.Image) image.Image {
+	if _, _, ok := nativeGrayPixels(img); ok {
+		return img
+	}
+	return toGray(img)
+}
+
+// grayImageStack 同じサイズのグレイスケール画像の画素を順番に書き込み, 画像の文字を記録する
+// IDXや.npyのように画像を1つの配列として出力する形式で使う
+type grayImageStack struct {
+	mu      sync.Mutex
+	w       io.Writer
+	rows    int
+	cols    int
+	classes []labelClass
+}
+
+// labelClass ラベルを振る単位. 文字コードごとに1つのクラスとする
+type labelClass struct {
+	code      uint16
+	character string
+}
+
+// add 画像の画素を書き込む. 1件目と異なるサイズの画像はエラーにする
+func (s *grayImageStack) add(record Record, img image.Image) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := img.Bounds()
+	if len(s.classes) == 0 {
+		s.rows, s.cols = b.Dy(), b.Dx()
+	} else if b.Dy() != s.rows || b.Dx() != s.cols {
+		return fmt.Errorf("image size %dx%d differs from %dx%d", b.Dx(), b.Dy(), s.cols, s.rows)
+	}
+
+	_, err := s.w.Write(grayPixels(img))
+	if err != nil {
+		return err
+	}
+	s.classes = append(s.classes, labelClass{code: record.GetCharacterCode(), character: record.GetCharacter()})
+	return nil
+}
+
+// LabelMapping 出力したラベルと文字の対応
+type LabelMapping struct {
+	Label int `json:"label"`
+
+	// CharacterCode レコードの文字コード(Record.GetCharacterCode). フォーマットによりJISもしくはCO-59
+	CharacterCode uint16 `json:"character_code"`
+
+	// Character 文字. 対応表にない文字コードの場合は空文字
+	Character string `json:"character"`
+}
+
+// buildLabels レコードの文字コード(Record.GetCharacterCode)の順に0からラベルを振る
+// 文字に変換できない文字コードもそれぞれ別のラベルにする
+// - classes: 出力した画像のクラス
+func buildLabels(classes []labelClass) (map[uint16]int, []LabelMapping) {
+	labels := map[uint16]int{}
+	uniq := []labelClass{}
+	for _, class := range classes {
+		if _, ok := labels[class.code]; !ok {
+			labels[class.code] = 0
+			uniq = append(uniq, class)
+		}
+	}
+	sort.Slice(uniq, func(i, j int) bool {
+		return uniq[i].code < uniq[j].code
+	})
+
+	mappings := make([]LabelMapping, len(uniq))
+	for i, class := range uniq {
+		labels[class.code] = i
+		mappings[i] = LabelMapping{Label: i, CharacterCode: class.code, Character: class.character}
+	}
+	return labels, mappings
+}
+
+// writeLabelMappings ラベルと文字の対応をJSONで出力する
+// - files: 出力するファイル
+// - name: 出力するファイルパス
+// - mappings: ラベルと文字の対応
+func writeLabelMappings(files *pendingFiles, name string, mappings []LabelMapping) error {
+	b, err := json.MarshalIndent(mappings, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := files.create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pendingFiles 一時ファイルへ書き込み, すべて書き終えてから出力するファイル名へ変更するファイルの集まり
+// 中断した場合に完成したように見えるファイルを残さないようにする
+type pendingFiles struct {
+	tmps  []string
+	names []string
+}
+
+// create nameと同じディレクトリに一時ファイルを作成する. commitでnameへ名前を変更する
+// - name: 出力するファイルパス
+func (p *pendingFiles) create(name string) (*os.File, error) {
+	f, err := ioutil.TempFile(path.Dir(name), "."+path.Base(name)+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	// TempFileは0600で作成するため, 他の出力と同じ権限にする
+	err = f.Chmod(0644)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	p.tmps = append(p.tmps, f.Name())
+	p.names = append(p.names, name)
+	return f, nil
+}
+
+// commit 一時ファイルを出力するファイル名へ変更する. 失敗した場合はすべて削除する
+func (p *pendingFiles) commit() error {
+	for i, tmp := range p.tmps {
+		err := os.Rename(tmp, p.names[i])
+		if err != nil {
+			for _, name := range p.names[:i] {
+				os.Remove(name)
+			}
+			p.tmps = p.tmps[i:]
+			p.remove()
+			return err
+		}
+	}
+	p.tmps, p.names = nil, nil
+	return nil
+}
+
+// remove 一時ファイルを削除する
+func (p *pendingFiles) remove() {
+	for _, tmp := range p.tmps {
+		os.Remove(tmp)
+	}
+	p.tmps, p.names = nil, nil
+}
+
+// commitReplacing commitした後, patternに一致するファイルのうち今回出力しなかったものを削除する
+// シャード数の異なる前回の出力など, 古いファイルが今回の出力と混ざらないようにする
+// - pattern: 前回の出力に一致するパターン(path.Matchの形式)
+func (p *pendingFiles) commitReplacing(pattern string) error {
+	written := map[string]bool{}
+	for _, name := range p.names {
+		written[name] = true
+	}
+
+	err := p.commit()
+	if err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, name := range matches {
+		if written[name] {
+			continue
+		}
+		err = os.Remove(name)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package formats
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"image"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/PyYoshi/etlcdb-tools/utils"
+)
+
+const (
+	// idxTypeUbyte IDX形式のデータ型 unsigned byte
+	idxTypeUbyte = 0x08
+
+	// idxTypeShort IDX形式のデータ型 short (2 bytes, big endian)
+	idxTypeShort = 0x0B
+)
+
+// ExportIDX MNISTと同じIDX形式でデータセットを出力する
+// 画像は<name>-images-idx3-ubyte, ラベルは<name>-labels-idx1-ubyteへ出力する.
+// クラス数が256を超える場合はラベルをshortで<name>-labels-idx1-shortへ出力する.
+// ラベルはレコードの文字コード(JISなど)の順に0から振り, 対応を<name>-labels.jsonへ出力する
+// - ctx: context
+// - format: フォーマット
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: 出力するディレクトリパス
+// - opts: オプション
+func ExportIDX(ctx context.Context, format ETLFormat, inputDir, outputDir string, opts ExportOptions) error {
+	spec, err := LookupFormat(format)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CreateIfNotExists(outputDir, true)
+	if err != nil {
+		return err
+	}
+
+	sink, err := NewIDXSink(path.Join(outputDir, strings.ToLower(spec.Name)))
+	if err != nil {
+		return err
+	}
+	return exportRecords(ctx, spec, inputDir, &opts, sink)
+}
+
+// idxSink 画像とラベルをIDX形式で出力するOutputSink
+type idxSink struct {
+	prefix string
+	files  *pendingFiles
+	f      *os.File
+	w      *bufio.Writer
+	stack  *grayImageStack
+}
+
+// NewIDXSink 画像とラベルをIDX形式で出力するOutputSinkを生成する
+// 画像は<prefix>-images-idx3-ubyteの一時ファイルへ書き込み, Closeでヘッダ, ラベル, ラベルと文字の対応を書き終えてから
+// 出力するファイル名へ変更する. 画像はすべて同じサイズであること
+// - prefix: 出力するファイルパスの接頭辞 e.g) datasets/etl7
+func NewIDXSink(prefix string) (OutputSink, error) {
+	files := &pendingFiles{}
+	f, err := files.create(prefix + "-images-idx3-ubyte")
+	if err != nil {
+		return nil, err
+	}
+
+	// ヘッダは画像数が決まってから書き込む
+	w := bufio.NewWriter(f)
+	_, err = w.Write(make([]byte, 16))
+	if err != nil {
+		f.Close()
+		files.remove()
+		return nil, err
+	}
+
+	return &idxSink{
+		prefix: prefix,
+		files:  files,
+		f:      f,
+		w:      w,
+		stack:  &grayImageStack{w: w},
+	}, nil
+}
+
+func (s *idxSink) WriteSample(key string, img image.Image, metadata Record) error {
+	return s.stack.add(metadata, img)
+}
+
+func (s *idxSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	f := s.f
+	s.f = nil
+	defer f.Close()
+
+	err := s.close(f)
+	if err != nil {
+		s.files.remove()
+		return err
+	}
+	return s.files.commit()
+}
+
+func (s *idxSink) Abort() error {
+	if s.f == nil {
+		return nil
+	}
+	s.f.Close()
+	s.f = nil
+	s.files.remove()
+	return nil
+}
+
+// close ヘッダ, ラベル, ラベルと文字の対応を一時ファイルへ書き終える
+func (s *idxSink) close(f *os.File) error {
+	err := s.w.Flush()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint32(header[0:], uint32(idxTypeUbyte)<<8|3)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(s.stack.classes)))
+	binary.BigEndian.PutUint32(header[8:], uint32(s.stack.rows))
+	binary.BigEndian.PutUint32(header[12:], uint32(s.stack.cols))
+	_, err = f.WriteAt(header, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	labels, mappings := buildLabels(s.stack.classes)
+	err = writeIDXLabels(s.files, s.prefix, s.stack.classes, labels)
+	if err != nil {
+		return err
+	}
+	return writeLabelMappings(s.files, s.prefix+"-labels.json", mappings)
+}
+
+// writeIDXLabels ラベルをIDX形式で出力する
+// - files: 出力するファイル
+// - prefix: 出力するファイルパスの接頭辞
+// - classes: 画像の順番に並べたクラス
+// - labels: 文字に対応するラベル
+func writeIDXLabels(files *pendingFiles, prefix string, classes []labelClass, labels map[uint16]int) error {
+	var err error
+
+	dataType := byte(idxTypeUbyte)
+	name := prefix + "-labels-idx1-ubyte"
+	if len(labels) > 256 {
+		dataType = idxTypeShort
+		name = prefix + "-labels-idx1-short"
+	}
+
+	f, err := files.create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:], uint32(dataType)<<8|1)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(classes)))
+	_, err = w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, class := range classes {
+		label := labels[class.code]
+		if dataType == idxTypeUbyte {
+			err = w.WriteByte(byte(label))
+		} else {
+			err = binary.Write(w, binary.BigEndian, int16(label))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,185 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// testSample OutputSinkへ渡すテスト用のサンプル
+type testSample struct {
+	code      uint16
+	character string
+}
+
+// testSampleImage i番目のサンプルの画像. 幅2, 高さ3で画素ごとに異なる値にする
+func testSampleImage(i int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 2, 3))
+	for j := range img.Pix {
+		img.Pix[j] = byte(i*10 + j)
+	}
+	return img
+}
+
+// writeTestSamples サンプルをsinkへ書き込む
+func writeTestSamples(t *testing.T, sink OutputSink, samples []testSample) {
+	t.Helper()
+	for i, sample := range samples {
+		record := &RecordBinary{
+			Format:           ETLFormat8b,
+			Character:        sample.character,
+			ImageName:        "sample.png",
+			JisCharacterCode: sample.code,
+		}
+		err := sink.WriteSample(record.GetKey(), testSampleImage(i), record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readLabelMappings ラベルと文字の対応を読み込む
+func readLabelMappings(t *testing.T, fpath string) []LabelMapping {
+	t.Helper()
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mappings := []LabelMapping{}
+	err = json.Unmarshal(b, &mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mappings
+}
+
+func TestIDXSink(t *testing.T) {
+	// 文字コード順にラベルを振り, 文字に変換できない文字コードも区別する
+	samples := []testSample{{0x3021, "亜"}, {0x2422, "あ"}, {0x2f22, ""}, {0x2f21, ""}, {0x3021, "亜"}}
+	wantLabels := []int{3, 0, 2, 1, 3}
+	wantMappings := []LabelMapping{
+		{Label: 0, CharacterCode: 0x2422, Character: "あ"},
+		{Label: 1, CharacterCode: 0x2f21},
+		{Label: 2, CharacterCode: 0x2f22},
+		{Label: 3, CharacterCode: 0x3021, Character: "亜"},
+	}
+
+	// クラス数が256を超える場合はshortにする
+	manySamples := []testSample{}
+	manyLabels := []int{}
+	manyMappings := make([]LabelMapping, 300)
+	for i := 0; i < 300; i++ {
+		manySamples = append(manySamples, testSample{code: uint16(0x4000 - i)})
+		manyLabels = append(manyLabels, 299-i)
+		manyMappings[299-i] = LabelMapping{Label: 299 - i, CharacterCode: uint16(0x4000 - i)}
+	}
+
+	cases := []struct {
+		name         string
+		samples      []testSample
+		labelsName   string
+		dataType     byte
+		wantLabels   []int
+		wantMappings []LabelMapping
+	}{
+		{"ubyte", samples, "test-labels-idx1-ubyte", idxTypeUbyte, wantLabels, wantMappings},
+		{"short", manySamples, "test-labels-idx1-short", idxTypeShort, manyLabels, manyMappings},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "idx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			sink, err := NewIDXSink(path.Join(dir, "test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestSamples(t, sink, c.samples)
+			err = sink.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n := len(c.samples)
+			images, err := ioutil.ReadFile(path.Join(dir, "test-images-idx3-ubyte"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := []uint32{0x00000803, uint32(n), 3, 2}
+			if len(images) != 16+n*6 {
+				t.Fatalf("images size = %d, want %d", len(images), 16+n*6)
+			}
+			for i, want := range header {
+				if got := binary.BigEndian.Uint32(images[i*4:]); got != want {
+					t.Errorf("images header[%d] = %#x, want %#x", i, got, want)
+				}
+			}
+			for i := 0; i < n; i++ {
+				if got := images[16+i*6 : 16+(i+1)*6]; !bytes.Equal(got, testSampleImage(i).Pix) {
+					t.Errorf("image %d = % x, want % x", i, got, testSampleImage(i).Pix)
+				}
+			}
+
+			labels, err := ioutil.ReadFile(path.Join(dir, c.labelsName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := binary.BigEndian.Uint32(labels), uint32(c.dataType)<<8|1; got != want {
+				t.Errorf("labels magic = %#x, want %#x", got, want)
+			}
+			if got := binary.BigEndian.Uint32(labels[4:]); got != uint32(n) {
+				t.Errorf("labels count = %d, want %d", got, n)
+			}
+			got := []int{}
+			for b := labels[8:]; len(b) > 0; {
+				if c.dataType == idxTypeUbyte {
+					got = append(got, int(b[0]))
+					b = b[1:]
+				} else {
+					got = append(got, int(int16(binary.BigEndian.Uint16(b))))
+					b = b[2:]
+				}
+			}
+			if !reflect.DeepEqual(got, c.wantLabels) {
+				t.Errorf("labels = %v, want %v", got, c.wantLabels)
+			}
+
+			mappings := readLabelMappings(t, path.Join(dir, "test-labels.json"))
+			if !reflect.DeepEqual(mappings, c.wantMappings) {
+				t.Errorf("label mappings = %+v, want %+v", mappings, c.wantMappings)
+			}
+		})
+	}
+}
+
+func TestIDXSinkAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := NewIDXSink(path.Join(dir, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestSamples(t, sink, []testSample{{0x3021, "亜"}})
+	sink.Abort()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		t.Errorf("%s remains after abort", fi.Name())
+	}
+}
@@ -0,0 +1,258 @@
+package formats
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/PyYoshi/etlcdb-tools/utils"
+)
+
+// npyMagic .npyの先頭に置くマジックナンバーとバージョン(1.0)
+const npyMagic = "\x93NUMPY\x01\x00"
+
+// ExportNpy NumPyの.npy形式でデータセットを出力する
+// 画像はuint8の(N, H, W)の配列として<name>-images.npy, ラベルはint32の(N,)の配列として<name>-labels.npyへ出力する.
+// ラベルはレコードの文字コード(JISなど)の順に0から振り, 対応を<name>-classes.jsonへ出力する
+// - ctx: context
+// - format: フォーマット
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: 出力するディレクトリパス
+// - opts: オプション
+func ExportNpy(ctx context.Context, format ETLFormat, inputDir, outputDir string, opts ExportOptions) error {
+	return exportNumPy(ctx, format, inputDir, outputDir, opts, false)
+}
+
+// ExportNpz ExportNpyと同じ配列をimages.npy, labels.npyとしてzipにまとめ<name>.npzへ出力する
+// numpy.loadで読み込める. ラベルと文字の対応は<name>-classes.jsonへ出力する
+// - ctx: context
+// - format: フォーマット
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: 出力するディレクトリパス
+// - opts: オプション
+func ExportNpz(ctx context.Context, format ETLFormat, inputDir, outputDir string, opts ExportOptions) error {
+	return exportNumPy(ctx, format, inputDir, outputDir, opts, true)
+}
+
+func exportNumPy(ctx context.Context, format ETLFormat, inputDir, outputDir string, opts ExportOptions, npz bool) error {
+	spec, err := LookupFormat(format)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CreateIfNotExists(outputDir, true)
+	if err != nil {
+		return err
+	}
+
+	sink, err := NewNumPySink(path.Join(outputDir, strings.ToLower(spec.Name)), npz)
+	if err != nil {
+		return err
+	}
+	return exportRecords(ctx, spec, inputDir, &opts, sink)
+}
+
+// numPySink 画像とラベルをNumPyの配列として出力するOutputSink
+type numPySink struct {
+	prefix string
+	npz    bool
+	files  *pendingFiles
+	tmp    *os.File
+	w      *bufio.Writer
+	stack  *grayImageStack
+}
+
+// NewNumPySink 画像とラベルをNumPyの配列として出力するOutputSinkを生成する
+// npzがfalseの場合は<prefix>-images.npyと<prefix>-labels.npy, trueの場合は<prefix>.npzへCloseで出力する.
+// Closeでは一時ファイルへすべて書き終えてから出力するファイル名へ変更する.
+// ラベルと文字の対応は<prefix>-classes.jsonへ出力する. 画像はすべて同じサイズであること
+// - prefix: 出力するファイルパスの接頭辞 e.g) datasets/etl7
+// - npz: trueの場合は.npzにまとめる
+func NewNumPySink(prefix string, npz bool) (OutputSink, error) {
+	// 画像数が決まるまでヘッダを書けないため, 画素は一時ファイルへ書き込む
+	tmp, err := ioutil.TempFile(path.Dir(prefix), "."+path.Base(prefix)+"-images-")
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(tmp)
+	return &numPySink{
+		prefix: prefix,
+		npz:    npz,
+		files:  &pendingFiles{},
+		tmp:    tmp,
+		w:      w,
+		stack:  &grayImageStack{w: w},
+	}, nil
+}
+
+func (s *numPySink) WriteSample(key string, img image.Image, metadata Record) error {
+	return s.stack.add(metadata, img)
+}
+
+func (s *numPySink) Close() error {
+	if s.tmp == nil {
+		return nil
+	}
+	tmp := s.tmp
+	s.tmp = nil
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err := s.close(tmp)
+	if err != nil {
+		s.files.remove()
+		return err
+	}
+	return s.files.commit()
+}
+
+func (s *numPySink) Abort() error {
+	if s.tmp == nil {
+		return nil
+	}
+	s.tmp.Close()
+	os.Remove(s.tmp.Name())
+	s.tmp = nil
+	s.files.remove()
+	return nil
+}
+
+// close 一時ファイルの画素から配列とラベルと文字の対応を一時ファイルへ書き終える
+func (s *numPySink) close(tmp *os.File) error {
+	err := s.w.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	n := len(s.stack.classes)
+	labels, mappings := buildLabels(s.stack.classes)
+	labelData := make([]byte, 4*n)
+	for i, class := range s.stack.classes {
+		binary.LittleEndian.PutUint32(labelData[i*4:], uint32(labels[class.code]))
+	}
+
+	images := io.MultiReader(bytes.NewReader(npyHeader("|u1", n, s.stack.rows, s.stack.cols)), tmp)
+	labelsNpy := io.MultiReader(bytes.NewReader(npyHeader("<i4", n)), bytes.NewReader(labelData))
+
+	if s.npz {
+		err = writeNpz(s.files, s.prefix+".npz", images, labelsNpy)
+	} else {
+		err = writeFileFrom(s.files, s.prefix+"-images.npy", images)
+		if err == nil {
+			err = writeFileFrom(s.files, s.prefix+"-labels.npy", labelsNpy)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeLabelMappings(s.files, s.prefix+"-classes.json", mappings)
+}
+
+// npyHeader .npy(バージョン1.0)のヘッダを生成する
+// ヘッダ全体の長さは64の倍数になるよう空白で埋める
+// - descr: 配列のデータ型 e.g) |u1
+// - shape: 配列の形
+func npyHeader(descr string, shape ...int) []byte {
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = fmt.Sprintf("%d", d)
+	}
+	shapeStr := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		shapeStr += ","
+	}
+
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, shapeStr)
+
+	// マジックナンバー, バージョン, ヘッダ長(2 bytes), 辞書, 改行
+	headerLen := len(dict) + 1
+	if pad := (len(npyMagic) + 2 + headerLen) % 64; pad != 0 {
+		headerLen += 64 - pad
+	}
+
+	header := make([]byte, 0, len(npyMagic)+2+headerLen)
+	header = append(header, npyMagic...)
+	header = append(header, byte(headerLen), byte(headerLen>>8))
+	header = append(header, dict...)
+	for len(header) < cap(header)-1 {
+		header = append(header, ' ')
+	}
+	return append(header, '\n')
+}
+
+// writeFileFrom rの内容をファイルへ出力する
+// - files: 出力するファイル
+// - name: 出力するファイルパス
+// - r: 出力する内容
+func writeFileFrom(files *pendingFiles, name string, r io.Reader) error {
+	f, err := files.create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeNpz 画像とラベルの.npyをzipへまとめて.npzとして出力する
+// - files: 出力するファイル
+// - name: 出力するファイルパス
+// - images: images.npyの内容
+// - labels: labels.npyの内容
+func writeNpz(files *pendingFiles, name string, images, labels io.Reader) error {
+	f, err := files.create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	entries := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"images.npy", images},
+		{"labels.npy", labels},
+	}
+	for _, entry := range entries {
+		// 出力が実行日時に依存しないよう, 日時はzipで扱える最小の値にする
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Deflate,
+			Modified: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, entry.r)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,158 @@
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNpyHeader(t *testing.T) {
+	cases := []struct {
+		descr string
+		shape []int
+		dict  string
+	}{
+		{"|u1", []int{3, 64, 63}, "{'descr': '|u1', 'fortran_order': False, 'shape': (3, 64, 63), }"},
+		{"<i4", []int{3}, "{'descr': '<i4', 'fortran_order': False, 'shape': (3,), }"},
+		{"<i4", []int{0}, "{'descr': '<i4', 'fortran_order': False, 'shape': (0,), }"},
+	}
+	for _, c := range cases {
+		header := npyHeader(c.descr, c.shape...)
+		if len(header)%64 != 0 {
+			t.Errorf("%v: header size = %d, want a multiple of 64", c.shape, len(header))
+		}
+		if !strings.HasPrefix(string(header), npyMagic) {
+			t.Errorf("%v: magic = %q, want %q", c.shape, header[:len(npyMagic)], npyMagic)
+			continue
+		}
+		if n := int(binary.LittleEndian.Uint16(header[len(npyMagic):])); n != len(header)-len(npyMagic)-2 {
+			t.Errorf("%v: header length = %d, want %d", c.shape, n, len(header)-len(npyMagic)-2)
+		}
+		dict := string(header[len(npyMagic)+2:])
+		if want := c.dict + strings.Repeat(" ", len(dict)-len(c.dict)-1) + "\n"; dict != want {
+			t.Errorf("%v: header = %q, want %q", c.shape, dict, want)
+		}
+	}
+}
+
+// readNpy .npyのヘッダを確かめて配列のデータを返す
+func readNpy(t *testing.T, b []byte, descr string, shape ...int) []byte {
+	t.Helper()
+	header := npyHeader(descr, shape...)
+	if !bytes.HasPrefix(b, header) {
+		n := len(header)
+		if len(b) < n {
+			n = len(b)
+		}
+		t.Fatalf("header = %q, want %q", b[:n], header)
+	}
+	return b[len(header):]
+}
+
+func TestNumPySink(t *testing.T) {
+	samples := []testSample{{0x3021, "亜"}, {0x2422, "あ"}, {0x2f21, ""}, {0x3021, "亜"}}
+	wantLabels := []int32{2, 0, 1, 2}
+	wantMappings := []LabelMapping{
+		{Label: 0, CharacterCode: 0x2422, Character: "あ"},
+		{Label: 1, CharacterCode: 0x2f21},
+		{Label: 2, CharacterCode: 0x3021, Character: "亜"},
+	}
+	wantImages := []byte{}
+	for i := range samples {
+		wantImages = append(wantImages, testSampleImage(i).Pix...)
+	}
+
+	cases := []struct {
+		name  string
+		npz   bool
+		files []string
+	}{
+		{"npy", false, []string{"test-classes.json", "test-images.npy", "test-labels.npy"}},
+		{"npz", true, []string{"test-classes.json", "test.npz"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "npy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			sink, err := NewNumPySink(path.Join(dir, "test"), c.npz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestSamples(t, sink, samples)
+			err = sink.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 一時ファイルは残らない
+			files := []string{}
+			fis, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, fi := range fis {
+				files = append(files, fi.Name())
+			}
+			if !reflect.DeepEqual(files, c.files) {
+				t.Errorf("files = %v, want %v", files, c.files)
+			}
+
+			arrays := map[string][]byte{}
+			if c.npz {
+				zr, err := zip.OpenReader(path.Join(dir, "test.npz"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer zr.Close()
+				for _, zf := range zr.File {
+					r, err := zf.Open()
+					if err != nil {
+						t.Fatal(err)
+					}
+					arrays[zf.Name], err = ioutil.ReadAll(r)
+					r.Close()
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			} else {
+				for _, name := range []string{"images.npy", "labels.npy"} {
+					arrays[name], err = ioutil.ReadFile(path.Join(dir, "test-"+name))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			images := readNpy(t, arrays["images.npy"], "|u1", len(samples), 3, 2)
+			if !bytes.Equal(images, wantImages) {
+				t.Errorf("images = % x, want % x", images, wantImages)
+			}
+
+			labelData := readNpy(t, arrays["labels.npy"], "<i4", len(samples))
+			labels := make([]int32, len(labelData)/4)
+			err = binary.Read(bytes.NewReader(labelData), binary.LittleEndian, labels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(labels, wantLabels) {
+				t.Errorf("labels = %v, want %v", labels, wantLabels)
+			}
+
+			mappings := readLabelMappings(t, path.Join(dir, "test-classes.json"))
+			if !reflect.DeepEqual(mappings, wantMappings) {
+				t.Errorf("label mappings = %+v, want %+v", mappings, wantMappings)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PyYoshi/etlcdb-tools/utils"
+)
+
+// TFRecordOptions TFRecordを出力する際のオプション
+type TFRecordOptions struct {
+	ExportOptions
+
+	// Shards 出力するファイル数. 1未満の場合は1
+	Shards int
+
+	// RawImage trueの場合, 画像をPNGではなく8bitグレイスケールの画素の配列として格納する
+	RawImage bool
+}
+
+// ExportTFRecord TensorFlowのTFRecord形式でデータセットを出力する
+// レコードごとに画像とメタデータをtf.train.Exampleへ格納し, <name>.tfrecord-00000-of-00004のようなファイルへ順番に振り分ける.
+// 画像はimage/encoded(PNG)もしくはimage/raw(RawImage)へ, サイズはimage/height, image/widthへ格納する.
+// メタデータはjsonと同じキーで, 文字列はbytes_list, 整数はint64_list, 小数はfloat_listとして格納する
+// - ctx: context
+// - format: フォーマット
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: 出力するディレクトリパス
+// - opts: オプション
+func ExportTFRecord(ctx context.Context, format ETLFormat, inputDir, outputDir string, opts TFRecordOptions) error {
+	spec, err := LookupFormat(format)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CreateIfNotExists(outputDir, true)
+	if err != nil {
+		return err
+	}
+
+	sink, err := NewTFRecordSink(path.Join(outputDir, strings.ToLower(spec.Name)), opts.Shards, opts.RawImage)
+	if err != nil {
+		return err
+	}
+	return exportRecords(ctx, spec, inputDir, &opts.ExportOptions, sink)
+}
+
+// tfRecordSink レコードをtf.train.ExampleとしてTFRecordのファイルへ順番に振り分けるOutputSink
+type tfRecordSink struct {
+	mu       sync.Mutex
+	prefix   string
+	files    *pendingFiles
+	writers  []*tfRecordWriter
+	rawImage bool
+	n        int
+}
+
+// NewTFRecordSink レコードをTFRecordで出力するOutputSinkを生成する
+// <prefix>.tfrecord-00000-of-00004のようなファイルをshards個作成し, サンプルを順番に振り分ける.
+// ファイルは一時ファイルへ書き込み, Closeで出力するファイル名へ変更して前回の出力の残りのファイルを削除する
+// - prefix: 出力するファイルパスの接頭辞 e.g) datasets/etl7
+// - shards: 出力するファイル数. 1未満の場合は1
+// - rawImage: trueの場合は画像を画素の配列として格納する
+func NewTFRecordSink(prefix string, shards int, rawImage bool) (OutputSink, error) {
+	if shards < 1 {
+		shards = 1
+	}
+
+	s := &tfRecordSink{
+		prefix:   prefix,
+		files:    &pendingFiles{},
+		writers:  make([]*tfRecordWriter, shards),
+		rawImage: rawImage,
+	}
+	for i := range s.writers {
+		f, err := s.files.create(fmt.Sprintf("%s.tfrecord-%05d-of-%05d", prefix, i, shards))
+		if err != nil {
+			s.Abort()
+			return nil, err
+		}
+		w := newTFRecordWriter(f)
+		s.writers[i] = w
+	}
+	return s, nil
+}
+
+func (s *tfRecordSink) WriteSample(key string, img image.Image, metadata Record) error {
+	example, err := encodeExample(metadata, img, s.rawImage)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.writers[s.n%len(s.writers)].write(example)
+	if err != nil {
+		return err
+	}
+	s.n++
+	return nil
+}
+
+func (s *tfRecordSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.close()
+	if err != nil {
+		s.files.remove()
+		return err
+	}
+	return s.files.commitReplacing(s.prefix + ".tfrecord-[0-9][0-9][0-9][0-9][0-9]-of-[0-9][0-9][0-9][0-9][0-9]")
+}
+
+func (s *tfRecordSink) Abort() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.close()
+	s.files.remove()
+	return nil
+}
+
+// close すべてのファイルを閉じる
+func (s *tfRecordSink) close() error {
+	var err error
+	for i, w := range s.writers {
+		if w == nil {
+			continue
+		}
+		cerr := w.close()
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+		s.writers[i] = nil
+	}
+	return err
+}
+
+// encodeExample レコードの画像とメタデータをtf.train.Exampleへエンコードする
+// - record: レコード
+// - img: リサイズした画像
+// - rawImage: trueの場合は画像を画素の配列として格納する
+func encodeExample(record Record, img image.Image, rawImage bool) ([]byte, error) {
+	rjb, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	keys, values, err := decodeOrderedObject(rjb)
+	if err != nil {
+		return nil, err
+	}
+
+	features := map[string]tfFeature{}
+	for i, key := range keys {
+		feature, ok, err := jsonTFFeature(values[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		if ok {
+			features[key] = feature
+		}
+	}
+
+	gray := grayImage(img)
+	b := gray.Bounds()
+	features["image/height"] = tfInt64Feature(int64(b.Dy()))
+	features["image/width"] = tfInt64Feature(int64(b.Dx()))
+	if rawImage {
+		features["image/raw"] = tfBytesFeature(grayPixels(gray))
+		features["image/format"] = tfBytesFeature([]byte("raw"))
+	} else {
+		buf := bytes.Buffer{}
+		err = png.Encode(&buf, gray)
+		if err != nil {
+			return nil, err
+		}
+		features["image/encoded"] = tfBytesFeature(buf.Bytes())
+		features["image/format"] = tfBytesFeature([]byte("png"))
+	}
+
+	return encodeTFExample(features), nil
+}
+
+// tfFeature エンコード済みのtf.train.Feature
+type tfFeature []byte
+
+const (
+	tfFeatureBytesList = 1
+	tfFeatureFloatList = 2
+	tfFeatureInt64List = 3
+)
+
+// tfBytesFeature bytes_listのtf.train.Feature
+func tfBytesFeature(values ...[]byte) tfFeature {
+	list := &protoBuffer{}
+	for _, v := range values {
+		list.bytesField(1, v)
+	}
+	feature := &protoBuffer{}
+	feature.bytesField(tfFeatureBytesList, list.b)
+	return feature.b
+}
+
+// tfInt64Feature int64_listのtf.train.Feature
+func tfInt64Feature(values ...int64) tfFeature {
+	packed := &protoBuffer{}
+	for _, v := range values {
+		packed.varint(uint64(v))
+	}
+	list := &protoBuffer{}
+	list.bytesField(1, packed.b)
+	feature := &protoBuffer{}
+	feature.bytesField(tfFeatureInt64List, list.b)
+	return feature.b
+}
+
+// tfFloatFeature float_listのtf.train.Feature
+func tfFloatFeature(values ...float32) tfFeature {
+	packed := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(packed[i*4:], math.Float32bits(v))
+	}
+	list := &protoBuffer{}
+	list.bytesField(1, packed)
+	feature := &protoBuffer{}
+	feature.bytesField(tfFeatureFloatList, list.b)
+	return feature.b
+}
+
+// jsonTFFeature メタデータのJSONの値をtf.train.Featureにする. nullの場合はfalseを返す
+func jsonTFFeature(value json.RawMessage) (tfFeature, bool, error) {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, false, err
+	}
+
+	values, ok := v.([]interface{})
+	if !ok {
+		if v == nil {
+			return nil, false, nil
+		}
+		values = []interface{}{v}
+	}
+
+	// 要素の型で格納するリストを決める. 整数のみの場合はint64_list, 数値のみの場合はfloat_list
+	ints := make([]int64, 0, len(values))
+	floats := make([]float32, 0, len(values))
+	strs := make([][]byte, 0, len(values))
+	for _, e := range values {
+		switch e := e.(type) {
+		case json.Number:
+			if i, err := strconv.ParseInt(string(e), 10, 64); err == nil {
+				ints = append(ints, i)
+			}
+			f, err := e.Float64()
+			if err != nil {
+				return nil, false, err
+			}
+			floats = append(floats, float32(f))
+		case bool:
+			if e {
+				ints = append(ints, 1)
+				floats = append(floats, 1)
+			} else {
+				ints = append(ints, 0)
+				floats = append(floats, 0)
+			}
+		case string:
+			strs = append(strs, []byte(e))
+		default:
+			// オブジェクトなどはJSONのまま格納する
+			return tfBytesFeature(value), true, nil
+		}
+	}
+
+	switch {
+	case len(strs) == len(values):
+		return tfBytesFeature(strs...), true, nil
+	case len(ints) == len(values):
+		return tfInt64Feature(ints...), true, nil
+	case len(floats) == len(values):
+		return tfFloatFeature(floats...), true, nil
+	}
+	return tfBytesFeature(value), true, nil
+}
+
+// encodeTFExample tf.train.Exampleをエンコードする. featureはキー順に並べる
+func encodeTFExample(features map[string]tfFeature) []byte {
+	keys := make([]string, 0, len(features))
+	for key := range features {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Features { map<string, Feature> feature = 1; }
+	fs := &protoBuffer{}
+	for _, key := range keys {
+		entry := &protoBuffer{}
+		entry.bytesField(1, []byte(key))
+		entry.bytesField(2, features[key])
+		fs.bytesField(1, entry.b)
+	}
+
+	// Example { Features features = 1; }
+	example := &protoBuffer{}
+	example.bytesField(1, fs.b)
+	return example.b
+}
+
+// protoBuffer tf.train.Exampleのエンコードに必要な最小限のprotobufエンコーダ
+type protoBuffer struct {
+	b []byte
+}
+
+// varint 可変長整数を書き込む
+func (p *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		p.b = append(p.b, byte(v)|0x80)
+		v >>= 7
+	}
+	p.b = append(p.b, byte(v))
+}
+
+// bytesField length-delimitedのフィールドを書き込む
+func (p *protoBuffer) bytesField(field int, b []byte) {
+	p.varint(uint64(field)<<3 | 2)
+	p.varint(uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+// crc32c TFRecordのチェックサムに使うCRC32C(Castagnoli)のテーブル
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// maskedCRC32C TFRecordのマスクしたCRC32C
+func maskedCRC32C(b []byte) uint32 {
+	crc := crc32.Checksum(b, crc32c)
+	return (crc>>15 | crc<<17) + 0xa282ead8
+}
+
+// tfRecordWriter TFRecordのファイルへレコードを書き込む
+type tfRecordWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newTFRecordWriter(f *os.File) *tfRecordWriter {
+	return &tfRecordWriter{f: f, w: bufio.NewWriter(f)}
+}
+
+// write 長さ(uint64), 長さのCRC, データ, データのCRCの順に書き込む
+func (w *tfRecordWriter) write(data []byte) error {
+	var err error
+
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint64(header, uint64(len(data)))
+	binary.LittleEndian.PutUint32(header[8:], maskedCRC32C(header[:8]))
+	_, err = w.w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, maskedCRC32C(data))
+	_, err = w.w.Write(footer)
+	return err
+}
+
+func (w *tfRecordWriter) close() error {
+	err := w.w.Flush()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
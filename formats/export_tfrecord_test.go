@@ -0,0 +1,110 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMaskedCRC32C(t *testing.T) {
+	cases := []struct {
+		data []byte
+		want uint32
+	}{
+		// CRC32C("123456789")は0xe3069283
+		{[]byte("123456789"), 0xc78ab0e5},
+		// 長さ0のレコードのヘッダ
+		{make([]byte, 8), 0x07980329},
+	}
+	for _, c := range cases {
+		got := maskedCRC32C(c.data)
+		if got != c.want {
+			t.Errorf("maskedCRC32C(%q) = %#08x, want %#08x", c.data, got, c.want)
+		}
+	}
+}
+
+func TestEncodeTFExample(t *testing.T) {
+	got := encodeTFExample(map[string]tfFeature{
+		"label": tfInt64Feature(300),
+		"a":     tfBytesFeature([]byte("x")),
+	})
+	want := []byte{
+		// Example.features
+		0x0a, 0x1d,
+		// Features.feature {key: "a", value: {bytes_list {value: "x"}}}
+		0x0a, 0x0a, 0x0a, 0x01, 'a', 0x12, 0x05, 0x0a, 0x03, 0x0a, 0x01, 'x',
+		// Features.feature {key: "label", value: {int64_list {value: 300}}}
+		0x0a, 0x0f, 0x0a, 0x05, 'l', 'a', 'b', 'e', 'l', 0x12, 0x06, 0x1a, 0x04, 0x0a, 0x02, 0xac, 0x02,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encodeTFExample = % x, want % x", got, want)
+	}
+}
+
+func TestTFRecordWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tfrecord")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	examples := [][]byte{
+		encodeTFExample(map[string]tfFeature{"label": tfInt64Feature(1)}),
+		{},
+		encodeTFExample(map[string]tfFeature{"image": tfBytesFeature(bytes.Repeat([]byte{0xff}, 300))}),
+	}
+
+	name := path.Join(dir, "test.tfrecord")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTFRecordWriter(f)
+	for _, example := range examples {
+		err = w.write(example)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, example := range examples {
+		if len(b) < 12 {
+			t.Fatalf("record %d: header is truncated", i)
+		}
+		n := binary.LittleEndian.Uint64(b)
+		if n != uint64(len(example)) {
+			t.Errorf("record %d: length = %d, want %d", i, n, len(example))
+		}
+		if crc := binary.LittleEndian.Uint32(b[8:]); crc != maskedCRC32C(b[:8]) {
+			t.Errorf("record %d: length crc = %#08x, want %#08x", i, crc, maskedCRC32C(b[:8]))
+		}
+		b = b[12:]
+
+		if uint64(len(b)) < n+4 {
+			t.Fatalf("record %d: data is truncated", i)
+		}
+		data := b[:n]
+		if !bytes.Equal(data, example) {
+			t.Errorf("record %d: data = % x, want % x", i, data, example)
+		}
+		if crc := binary.LittleEndian.Uint32(b[n:]); crc != maskedCRC32C(data) {
+			t.Errorf("record %d: data crc = %#08x, want %#08x", i, crc, maskedCRC32C(data))
+		}
+		b = b[n+4:]
+	}
+	if len(b) != 0 {
+		t.Errorf("%d bytes remain after the last record", len(b))
+	}
+}
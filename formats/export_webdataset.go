@@ -0,0 +1,257 @@
+package formats
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PyYoshi/etlcdb-tools/utils"
+)
+
+// defaultShardMaxSamples WebDatasetOptionsで上限を指定しなかった場合の1シャードあたりのサンプル数
+const defaultShardMaxSamples = 10000
+
+// WebDatasetOptions WebDataset形式で出力する際のオプション
+type WebDatasetOptions struct {
+	ExportOptions
+
+	// ShardMaxSamples 1シャードあたりのサンプル数の上限. 0の場合は制限しない
+	// ShardMaxBytesも0の場合はdefaultShardMaxSamplesにする
+	ShardMaxSamples int
+
+	// ShardMaxBytes 1シャードあたりのバイト数の上限. 0の場合は制限しない
+	// 1サンプルで上限を超える場合はそのサンプルのみのシャードになる
+	ShardMaxBytes int64
+}
+
+// ExportWebDataset WebDatasetの規約に従ったtarのシャードでデータセットを出力する
+// シャードは<name>-000000.tarのように連番で出力し, 各サンプルは<key>.png(画像)と<key>.json(メタデータ)として格納する.
+// keyはRecord.GetKeyから拡張子を除いたもの
+// - ctx: context
+// - format: フォーマット
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: 出力するディレクトリパス
+// - opts: オプション
+func ExportWebDataset(ctx context.Context, format ETLFormat, inputDir, outputDir string, opts WebDatasetOptions) error {
+	spec, err := LookupFormat(format)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CreateIfNotExists(outputDir, true)
+	if err != nil {
+		return err
+	}
+
+	sink := NewWebDatasetSink(path.Join(outputDir, strings.ToLower(spec.Name)), opts.ShardMaxSamples, opts.ShardMaxBytes)
+	return exportRecords(ctx, spec, inputDir, &opts.ExportOptions, sink)
+}
+
+// webDatasetSink サンプルをWebDatasetのtarのシャードへ書き込むOutputSink
+type webDatasetSink struct {
+	mu sync.Mutex
+	w  *tarShardWriter
+}
+
+// NewWebDatasetSink サンプルをWebDatasetのtarのシャードへ書き込むOutputSinkを生成する
+// シャードは<prefix>-000000.tarのように連番で作成し, 各サンプルは<key>.png(画像)と<key>.json(メタデータ)として格納する.
+// keyは拡張子を除いたもの. シャードは一時ファイルへ書き込み, Closeで出力するファイル名へ変更して前回の出力の残りのシャードを削除する
+// - prefix: 出力するファイルパスの接頭辞 e.g) datasets/etl7
+// - maxSamples: 1シャードあたりのサンプル数の上限. 0の場合は制限しない
+// - maxBytes: 1シャードあたりのバイト数の上限. 0の場合は制限しない
+func NewWebDatasetSink(prefix string, maxSamples int, maxBytes int64) OutputSink {
+	if maxSamples <= 0 && maxBytes <= 0 {
+		maxSamples = defaultShardMaxSamples
+	}
+	return &webDatasetSink{
+		w: &tarShardWriter{
+			prefix:     prefix,
+			files:      &pendingFiles{},
+			maxSamples: maxSamples,
+			maxBytes:   maxBytes,
+		},
+	}
+}
+
+func (s *webDatasetSink) WriteSample(key string, img image.Image, metadata Record) error {
+	buf := bytes.Buffer{}
+	enc := &png.Encoder{CompressionLevel: png.BestCompression}
+	err := enc.Encode(&buf, grayImage(img))
+	if err != nil {
+		return err
+	}
+
+	rjb, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.writeSample(strings.TrimSuffix(key, path.Ext(key)), map[string][]byte{
+		"png":  buf.Bytes(),
+		"json": rjb,
+	})
+}
+
+func (s *webDatasetSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.w.close()
+	if err != nil {
+		s.w.files.remove()
+		return err
+	}
+	return s.w.files.commitReplacing(tarShardPattern(s.w.prefix))
+}
+
+func (s *webDatasetSink) Abort() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.w.close()
+	s.w.files.remove()
+	return nil
+}
+
+// tarShardName シャードのファイル名
+func tarShardName(prefix string, shard int) string {
+	return fmt.Sprintf("%s-%06d.tar", prefix, shard)
+}
+
+// tarShardPattern tarShardNameのファイル名に一致するパターン
+func tarShardPattern(prefix string) string {
+	return prefix + "-[0-9][0-9][0-9][0-9][0-9][0-9].tar"
+}
+
+// tarShardWriter サンプルを上限に達するごとに新しいtarのシャードへ書き込む
+type tarShardWriter struct {
+	prefix     string
+	maxSamples int
+	maxBytes   int64
+
+	// files 書き込んだシャードの一時ファイル
+	files *pendingFiles
+
+	// shard 次に作成するシャードの番号
+	shard   int
+	f       *os.File
+	bw      *bufio.Writer
+	tw      *tar.Writer
+	samples int
+	bytes   int64
+}
+
+// tarTrailerSize tarの終端に置く空のブロックのサイズ
+const tarTrailerSize = 1024
+
+// tarEntrySize tarへ格納した際のエントリのサイズ(ヘッダと512バイト単位のデータ)
+func tarEntrySize(size int) int64 {
+	return 512 + (int64(size)+511)/512*512
+}
+
+// writeSample 1サンプル分のファイルを書き込む. 上限に達する場合は新しいシャードを作成する
+// - key: サンプルのキー
+// - files: 拡張子とファイルの内容. 拡張子順に格納する
+func (w *tarShardWriter) writeSample(key string, files map[string][]byte) error {
+	var err error
+
+	exts := make([]string, 0, len(files))
+	var size int64
+	for ext, b := range files {
+		exts = append(exts, ext)
+		size += tarEntrySize(len(b))
+	}
+	sort.Strings(exts)
+
+	if w.tw == nil || w.full(size) {
+		err = w.next()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ext := range exts {
+		b := files[ext]
+		err = w.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     key + "." + ext,
+			Size:     int64(len(b)),
+			Mode:     0644,
+			ModTime:  time.Unix(0, 0),
+			Format:   tar.FormatUSTAR,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = w.tw.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+	w.samples++
+	w.bytes += size
+	return nil
+}
+
+// full sizeバイトのサンプルを追加すると現在のシャードが上限を超えるかどうか
+func (w *tarShardWriter) full(size int64) bool {
+	if w.samples == 0 {
+		return false
+	}
+	if w.maxSamples > 0 && w.samples >= w.maxSamples {
+		return true
+	}
+	return w.maxBytes > 0 && w.bytes+size+tarTrailerSize > w.maxBytes
+}
+
+// next 現在のシャードを閉じて次のシャードを作成する
+func (w *tarShardWriter) next() error {
+	err := w.close()
+	if err != nil {
+		return err
+	}
+
+	f, err := w.files.create(tarShardName(w.prefix, w.shard))
+	if err != nil {
+		return err
+	}
+	w.shard++
+	w.f = f
+	w.bw = bufio.NewWriter(f)
+	w.tw = tar.NewWriter(w.bw)
+	w.samples = 0
+	w.bytes = 0
+	return nil
+}
+
+// close 現在のシャードを閉じる
+func (w *tarShardWriter) close() error {
+	if w.tw == nil {
+		return nil
+	}
+
+	f := w.f
+	err := w.tw.Close()
+	if err == nil {
+		err = w.bw.Flush()
+	}
+	w.f, w.bw, w.tw = nil, nil, nil
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
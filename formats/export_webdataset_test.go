@@ -0,0 +1,244 @@
+package formats
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// readTarShard シャードに格納されたファイル名と内容を格納順に読み込む
+func readTarShard(t *testing.T, fpath string) ([]string, map[string][]byte) {
+	t.Helper()
+	f, err := os.Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	names := []string{}
+	files := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", fpath, err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("%s: %v", fpath, err)
+		}
+		names = append(names, h.Name)
+		files[h.Name] = b
+	}
+	return names, files
+}
+
+func TestTarShardWriter(t *testing.T) {
+	// 1サンプルはjson(10バイト)とpng(100バイト)で, tarでは2048バイトになる
+	const sampleNum = 5
+	const sampleSize = 2048
+
+	cases := []struct {
+		name       string
+		maxSamples int
+		maxBytes   int64
+		shards     []int
+	}{
+		{"samples", 2, 0, []int{2, 2, 1}},
+		{"bytes", 0, 3*sampleSize + tarTrailerSize, []int{3, 2}},
+		{"bytes just over", 0, 3*sampleSize + tarTrailerSize - 1, []int{2, 2, 1}},
+		{"sample larger than max bytes", 0, sampleSize, []int{1, 1, 1, 1, 1}},
+		{"samples and bytes", 2, 10 * sampleSize, []int{2, 2, 1}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "webdataset")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			prefix := path.Join(dir, "test")
+			w := &tarShardWriter{
+				prefix:     prefix,
+				files:      &pendingFiles{},
+				maxSamples: c.maxSamples,
+				maxBytes:   c.maxBytes,
+			}
+			for i := 0; i < sampleNum; i++ {
+				err = w.writeSample(fmt.Sprintf("s%d", i), map[string][]byte{
+					"png":  bytes.Repeat([]byte{byte(i)}, 100),
+					"json": bytes.Repeat([]byte{'0' + byte(i)}, 10),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = w.close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = w.files.commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sample := 0
+			for shard, n := range c.shards {
+				fpath := tarShardName(prefix, shard)
+				names, files := readTarShard(t, fpath)
+
+				// 拡張子順に格納する
+				wantNames := []string{}
+				for i := sample; i < sample+n; i++ {
+					wantNames = append(wantNames, fmt.Sprintf("s%d.json", i), fmt.Sprintf("s%d.png", i))
+				}
+				if !reflect.DeepEqual(names, wantNames) {
+					t.Errorf("shard %d = %v, want %v", shard, names, wantNames)
+				}
+				for i := sample; i < sample+n; i++ {
+					if b := files[fmt.Sprintf("s%d.png", i)]; !bytes.Equal(b, bytes.Repeat([]byte{byte(i)}, 100)) {
+						t.Errorf("s%d.png = % x", i, b)
+					}
+				}
+				sample += n
+
+				fi, err := os.Stat(fpath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := int64(n*sampleSize + tarTrailerSize); fi.Size() != want {
+					t.Errorf("shard %d size = %d, want %d", shard, fi.Size(), want)
+				}
+				if c.maxBytes > 0 && n > 1 && fi.Size() > c.maxBytes {
+					t.Errorf("shard %d size = %d, larger than %d", shard, fi.Size(), c.maxBytes)
+				}
+			}
+			if _, err := os.Stat(tarShardName(prefix, len(c.shards))); !os.IsNotExist(err) {
+				t.Errorf("unexpected shard %d: %v", len(c.shards), err)
+			}
+		})
+	}
+}
+
+func TestWebDatasetSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webdataset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 前回の出力の残りのシャードは削除し, 他のファイルは残す
+	prefix := path.Join(dir, "test")
+	stale := []string{tarShardName(prefix, 0), tarShardName(prefix, 5)}
+	keep := []string{prefix + "-extra.tar", path.Join(dir, "other-000001.tar")}
+	for _, name := range append(stale, keep...) {
+		err = ioutil.WriteFile(name, []byte("stale"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink := NewWebDatasetSink(prefix, 2, 0)
+	samples := []testSample{{0x3021, "亜"}, {0x2422, "あ"}, {0x2f21, ""}}
+	for i, sample := range samples {
+		record := &RecordBinary{
+			Format:           ETLFormat8b,
+			Character:        sample.character,
+			ImageName:        fmt.Sprintf("sample%d.png", i),
+			JisCharacterCode: sample.code,
+		}
+		err = sink.WriteSample(record.GetKey(), testSampleImage(i), record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range keep {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(tarShardName(prefix, 5)); !os.IsNotExist(err) {
+		t.Errorf("stale shard remains: %v", err)
+	}
+
+	sample := 0
+	for shard, n := range []int{2, 1} {
+		names, files := readTarShard(t, tarShardName(prefix, shard))
+		if len(names) != 2*n {
+			t.Fatalf("shard %d = %v, want %d samples", shard, names, n)
+		}
+		for i := sample; i < sample+n; i++ {
+			key := fmt.Sprintf("sample%d", i)
+			img, err := png.Decode(bytes.NewReader(files[key+".png"]))
+			if err != nil {
+				t.Fatalf("%s.png: %v", key, err)
+			}
+			if gray := toGray(img); !bytes.Equal(gray.Pix, testSampleImage(i).Pix) {
+				t.Errorf("%s.png = % x, want % x", key, gray.Pix, testSampleImage(i).Pix)
+			}
+
+			var metadata struct {
+				ImageName string `json:"image_name"`
+				Character string `json:"character"`
+			}
+			err = json.Unmarshal(files[key+".json"], &metadata)
+			if err != nil {
+				t.Fatalf("%s.json: %v", key, err)
+			}
+			if metadata.ImageName != key+".png" || metadata.Character != samples[i].character {
+				t.Errorf("%s.json = %+v", key, metadata)
+			}
+		}
+		sample += n
+	}
+}
+
+func TestWebDatasetSinkAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webdataset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 中断した場合は前回の出力を残す
+	prefix := path.Join(dir, "test")
+	prev := tarShardName(prefix, 0)
+	err = ioutil.WriteFile(prev, []byte("previous"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := NewWebDatasetSink(prefix, 1, 0)
+	writeTestSamples(t, sink, []testSample{{0x3021, "亜"}, {0x2422, "あ"}})
+	sink.Abort()
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != path.Base(prev) {
+		for _, fi := range fis {
+			t.Errorf("%s remains after abort", fi.Name())
+		}
+	}
+	if b, _ := ioutil.ReadFile(prev); string(b) != "previous" {
+		t.Errorf("previous shard = %q, want %q", b, "previous")
+	}
+}
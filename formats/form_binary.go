@@ -0,0 +1,150 @@
+package formats
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/PyYoshi/etlcdb-tools/tables"
+)
+
+const (
+	// binarySampleWidth 2値画像形式のサンプリング画像の幅
+	binarySampleWidth = 64
+
+	// binarySampleHeight 2値画像形式のサンプリング画像の高さ
+	binarySampleHeight = 63
+
+	// binarySampleBitDepth 2値画像形式の1ピクセルあたりのbit数
+	binarySampleBitDepth = 1
+
+	// binarySamplePixelNum 2値画像形式のサンプル画像ピクセル数
+	binarySamplePixelNum = binarySampleWidth * binarySampleHeight
+
+	// binarySampleSize 2値画像形式のサンプル画像サイズ
+	binarySampleSize = binarySamplePixelNum / 8
+
+	// binaryRecordDataSize 2値画像形式のレコードのうちデータが格納されている部分のサイズ
+	binaryRecordDataSize = 2 + 2 + 4 + binarySampleSize
+)
+
+// RecordBinary 2値画像形式(ETL8B, ETL9B)共通のレコード
+type RecordBinary struct {
+	Format      ETLFormat   `json:"format"`
+	Character   string      `json:"character"`
+	Image       image.Image `json:"-"`
+	ImageHash   string      `json:"-"`
+	ImageName   string      `json:"image_name"`
+	ImageWidth  int         `json:"image_width"`
+	ImageHeight int         `json:"image_height"`
+
+	SerialSheetNumber uint16 `json:"serial_sheet_number"`
+	JisCharacterCode  uint16 `json:"jis_character_code"`
+	JisTypicalReading string `json:"jis_typical_reading"`
+}
+
+// DeallocImage RecordBinary.Imageにnilを代入する
+func (r *RecordBinary) DeallocImage() {
+	r.Image = nil
+}
+
+// GetKey フォーマット内でユニークなキー
+func (r *RecordBinary) GetKey() string {
+	return r.ImageName
+}
+
+// SetKey レコードのキー(画像ファイル名)を変更する
+func (r *RecordBinary) SetKey(key string) {
+	r.ImageName = key
+}
+
+// GetImage レコードに格納された画像. DeallocImage後はnil
+func (r *RecordBinary) GetImage() image.Image {
+	return r.Image
+}
+
+// GetBitDepth 画像の元の1ピクセルあたりのbit数
+func (r *RecordBinary) GetBitDepth() int {
+	return binarySampleBitDepth
+}
+
+// GetCharacter レコードの文字
+func (r *RecordBinary) GetCharacter() string {
+	return r.Character
+}
+
+// GetCharacterCode レコードの文字コード(JIS X 0208)
+func (r *RecordBinary) GetCharacterCode() uint16 {
+	return r.JisCharacterCode
+}
+
+// parseBinaryRecord 2値画像形式のレコードをパーズする
+// - fp: レコードを読み込むio.Reader
+// - format: レコードのフォーマット
+// - recordSize: レコードサイズ. データ部分以降は読み飛ばす
+func parseBinaryRecord(fp io.Reader, format ETLFormat, recordSize int) (RecordBinary, error) {
+	var err error
+	record := RecordBinary{
+		Format:      format,
+		ImageWidth:  binarySampleWidth,
+		ImageHeight: binarySampleHeight,
+	}
+
+	err = binary.Read(fp, binary.BigEndian, &record.SerialSheetNumber)
+	if err != nil {
+		return record, err
+	}
+
+	err = binary.Read(fp, binary.BigEndian, &record.JisCharacterCode)
+	if err != nil {
+		return record, err
+	}
+
+	jisTypicalReading := make([]byte, 4)
+	err = binary.Read(fp, binary.BigEndian, &jisTypicalReading)
+	if err != nil {
+		return record, err
+	}
+	record.JisTypicalReading = strings.TrimSpace(string(jisTypicalReading))
+
+	sampleImage := image.NewGray(image.Rect(0, 0, binarySampleWidth, binarySampleHeight))
+	pxIndex := 0
+	for i := 0; i < binarySampleSize; i++ {
+		var pxT uint8
+		err = binary.Read(fp, binary.BigEndian, &pxT)
+		if err != nil {
+			return record, err
+		}
+
+		// 8bitから1bitずつ取得し8bitへ
+		for b := uint(0); b < 8; b++ {
+			if pxT&(0x80>>b) != 0 {
+				sampleImage.Pix[pxIndex] = 0xFF
+			}
+			pxIndex++
+		}
+	}
+
+	// undefined
+	undefined1 := make([]byte, recordSize-binaryRecordDataSize)
+	err = binary.Read(fp, binary.BigEndian, &undefined1)
+	if err != nil {
+		return record, err
+	}
+
+	imgHash := sha256.New()
+	imgHash.Write(sampleImage.Pix)
+
+	if u, ok := tables.JIS0208[record.JisCharacterCode]; ok {
+		record.Character = string(rune(u))
+	}
+	record.Image = sampleImage
+	record.ImageHash = hex.EncodeToString(imgHash.Sum(nil))
+	record.ImageName = fmt.Sprintf("ETL%s_0x%x_%s.png", strings.ToUpper(string(format)), record.JisCharacterCode, record.ImageHash)
+
+	return record, nil
+}
@@ -0,0 +1,72 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseBinaryRecord(t *testing.T) {
+	bitmap := make([]byte, binarySampleSize)
+	for i := range bitmap {
+		bitmap[i] = byte(i * 37)
+	}
+
+	for _, recordSize := range []int{etl8bRecordSize, etl9bRecordSize} {
+		b := &bytes.Buffer{}
+		binary.Write(b, binary.BigEndian, uint16(0x0102)) // Serial Sheet Number
+		binary.Write(b, binary.BigEndian, uint16(0x3021)) // JIS Code (亜)
+		b.WriteString("AA  ")                             // JIS Typical Reading
+		b.Write(bitmap)
+		if b.Len() != binaryRecordDataSize {
+			t.Fatalf("data size = %d, want %d", b.Len(), binaryRecordDataSize)
+		}
+		b.Write(bytes.Repeat([]byte{0xff}, recordSize-binaryRecordDataSize)) // undefined
+
+		r := bytes.NewReader(b.Bytes())
+		record, err := parseBinaryRecord(r, ETLFormat9b, recordSize)
+		if err != nil {
+			t.Fatalf("record size %d: %v", recordSize, err)
+		}
+		if r.Len() != 0 {
+			t.Errorf("record size %d: %d bytes remain after the record", recordSize, r.Len())
+		}
+
+		if record.SerialSheetNumber != 0x0102 {
+			t.Errorf("SerialSheetNumber = %#x, want 0x0102", record.SerialSheetNumber)
+		}
+		if record.JisCharacterCode != 0x3021 {
+			t.Errorf("JisCharacterCode = %#x, want 0x3021", record.JisCharacterCode)
+		}
+		if record.JisTypicalReading != "AA" {
+			t.Errorf("JisTypicalReading = %q, want %q", record.JisTypicalReading, "AA")
+		}
+		if record.Character != "亜" {
+			t.Errorf("Character = %q, want %q", record.Character, "亜")
+		}
+
+		// 1バイトに8画素. 上位bitが先の画素で, 1を0xffにする
+		checkGrayPixels(t, record.Image, binarySampleWidth, binarySampleHeight, func(i int) uint8 {
+			if bitmap[i/8]&(0x80>>uint(i%8)) != 0 {
+				return 0xff
+			}
+			return 0
+		})
+	}
+}
+
+func TestParseBinaryRecordUnmappedCode(t *testing.T) {
+	b := make([]byte, etl8bRecordSize)
+	binary.BigEndian.PutUint16(b[2:], 0x2f21) // JIS Code. JIS X 0208にない文字コード
+
+	record, err := parseBinaryRecord(bytes.NewReader(b), ETLFormat8b, etl8bRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.JisCharacterCode != 0x2f21 {
+		t.Errorf("JisCharacterCode = %#x, want 0x2f21", record.JisCharacterCode)
+	}
+	if record.Character != "" {
+		t.Errorf("Character = %q, want empty", record.Character)
+	}
+}
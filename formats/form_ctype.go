@@ -0,0 +1,202 @@
+package formats
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+const (
+	// cTypeRecordSize C-typeのレコードサイズ
+	cTypeRecordSize = 2952
+
+	// cTypeSampleWidth C-typeのサンプリング画像の幅
+	cTypeSampleWidth = 72
+
+	// cTypeSampleHeight C-typeのサンプリング画像の高さ
+	cTypeSampleHeight = 76
+
+	// cTypeSamplePixelNum C-typeのサンプル画像ピクセル数
+	cTypeSamplePixelNum = cTypeSampleWidth * cTypeSampleHeight
+
+	// cTypeSampleBitDepth C-typeの1ピクセルあたりのbit数
+	cTypeSampleBitDepth = 4
+)
+
+// RecordCType C-type形式(ETL3, ETL4, ETL5)共通のレコード
+// 各フィールドは36bitワード単位で格納されている
+type RecordCType struct {
+	Format      ETLFormat   `json:"format"`
+	Character   string      `json:"character"`
+	Image       image.Image `json:"-"`
+	ImageHash   string      `json:"-"`
+	ImageName   string      `json:"image_name"`
+	ImageWidth  int         `json:"image_width"`
+	ImageHeight int         `json:"image_height"`
+
+	SerialDataNumber                            uint32   `json:"serial_data_number"`
+	SerialSheetNumber                           uint32   `json:"serial_sheet_number"`
+	JisCharacterCode                            uint8    `json:"jis_character_code"`
+	EbcdicCharacterCode                         uint8    `json:"ebcdic_character_code"`
+	TypicalReading                              [4]uint8 `json:"typical_reading"`
+	QualityEvaluationOfIndividualCharacterImage uint8    `json:"quality_evaluation_of_individual_character_image"`
+	QualityEvaluationOfCharacterGroup           uint8    `json:"quality_evaluation_of_character_group"`
+	YCoordinateOfSampleOnSheet                  uint8    `json:"y_coordinate_of_sample_on_sheet"`
+	XCoordinateOfSampleOnSheet                  uint8    `json:"x_coordinate_of_sample_on_sheet"`
+	GenderOfWriter                              uint8    `json:"gender_of_writer"`
+	AgeOfWriter                                 uint8    `json:"age_of_writer"`
+	IndustryClassificationCode                  uint16   `json:"industry_classification_code"`
+	OccupationClassificationCode                uint16   `json:"occupation_classification_code"`
+	DateOfCollection                            uint16   `json:"date_of_collection"`
+	DateOfScan                                  uint16   `json:"date_of_scan"`
+	NumberOfXAxisSamplingPoints                 uint8    `json:"number_of_x_axis_sampling_points"`
+	NumberOfYAxisSamplingPoints                 uint8    `json:"number_of_y_axis_sampling_points"`
+	NumberOfLevelsOfPixel                       uint8    `json:"number_of_levels_of_pixel"`
+	MagnificationOfScanningLens                 uint8    `json:"magnification_of_scanning_lens"`
+	SerialDataNumberOld                         uint32   `json:"serial_data_number_old"`
+}
+
+// DeallocImage RecordCType.Imageにnilを代入する
+func (r *RecordCType) DeallocImage() {
+	r.Image = nil
+}
+
+// GetKey フォーマット内でユニークなキー
+func (r *RecordCType) GetKey() string {
+	return r.ImageName
+}
+
+// SetKey レコードのキー(画像ファイル名)を変更する
+func (r *RecordCType) SetKey(key string) {
+	r.ImageName = key
+}
+
+// GetImage レコードに格納された画像. DeallocImage後はnil
+func (r *RecordCType) GetImage() image.Image {
+	return r.Image
+}
+
+// GetBitDepth 画像の元の1ピクセルあたりのbit数
+func (r *RecordCType) GetBitDepth() int {
+	return cTypeSampleBitDepth
+}
+
+// GetCharacter レコードの文字
+func (r *RecordCType) GetCharacter() string {
+	return r.Character
+}
+
+// GetCharacterCode レコードの文字コード(JIS X 0201)
+func (r *RecordCType) GetCharacterCode() uint16 {
+	return uint16(r.JisCharacterCode)
+}
+
+// parseCTypeRecord C-type形式のレコードをパーズする
+// - fp: レコードを読み込むio.Reader
+// - format: レコードのフォーマット
+func parseCTypeRecord(fp io.Reader, format ETLFormat) (RecordCType, error) {
+	var err error
+	record := RecordCType{
+		Format:      format,
+		ImageWidth:  cTypeSampleWidth,
+		ImageHeight: cTypeSampleHeight,
+	}
+
+	rb := make([]byte, cTypeRecordSize)
+	_, err = io.ReadFull(fp, rb)
+	if err != nil {
+		return record, err
+	}
+	br := NewBitReader(bytes.NewReader(rb))
+
+	// 36bitワードを読み込む
+	words := make([]uint64, 0, 20)
+	readWords := func(n int) error {
+		for i := 0; i < n; i++ {
+			v, err := br.ReadUint(36)
+			if err != nil {
+				return err
+			}
+			words = append(words, v)
+		}
+		return nil
+	}
+
+	// Serial Data Number, Serial Sheet Number
+	err = readWords(2)
+	if err != nil {
+		return record, err
+	}
+	record.SerialDataNumber = uint32(words[0])
+	record.SerialSheetNumber = uint32(words[1])
+
+	// JIS Code, EBCDIC Code (各ワードの上位8bit)
+	err = readWords(2)
+	if err != nil {
+		return record, err
+	}
+	record.JisCharacterCode = uint8(words[2] >> 28)
+	record.EbcdicCharacterCode = uint8(words[3] >> 28)
+
+	// 4 Characters of Reading (上位24bitに6bitずつ)
+	err = readWords(1)
+	if err != nil {
+		return record, err
+	}
+	for i := range record.TypicalReading {
+		record.TypicalReading[i] = uint8(words[4]>>uint(30-6*i)) & 0x3f
+	}
+
+	err = readWords(15)
+	if err != nil {
+		return record, err
+	}
+	record.QualityEvaluationOfIndividualCharacterImage = uint8(words[5])
+	record.QualityEvaluationOfCharacterGroup = uint8(words[6])
+	record.YCoordinateOfSampleOnSheet = uint8(words[7])
+	record.XCoordinateOfSampleOnSheet = uint8(words[8])
+	record.GenderOfWriter = uint8(words[9])
+	record.AgeOfWriter = uint8(words[10])
+	record.IndustryClassificationCode = uint16(words[11])
+	record.OccupationClassificationCode = uint16(words[12])
+	record.DateOfCollection = uint16(words[13])
+	record.DateOfScan = uint16(words[14])
+	record.NumberOfXAxisSamplingPoints = uint8(words[15])
+	record.NumberOfYAxisSamplingPoints = uint8(words[16])
+	record.NumberOfLevelsOfPixel = uint8(words[17])
+	record.MagnificationOfScanningLens = uint8(words[18])
+	record.SerialDataNumberOld = uint32(words[19])
+
+	// undefined
+	for i := 0; i < 1008/36; i++ {
+		_, err = br.ReadUint(36)
+		if err != nil {
+			return record, err
+		}
+	}
+
+	sampleImage := image.NewGray(image.Rect(0, 0, cTypeSampleWidth, cTypeSampleHeight))
+	for i := 0; i < cTypeSamplePixelNum; i++ {
+		px, err := br.ReadUint(cTypeSampleBitDepth)
+		if err != nil {
+			return record, err
+		}
+
+		// 4bitグレイスケールを8bitへ
+		sampleImage.Pix[i] = uint8(px) * (256 / 16)
+	}
+
+	imgHash := sha256.New()
+	imgHash.Write(sampleImage.Pix)
+
+	record.Character = jis0201Character(record.JisCharacterCode, format)
+	record.Image = sampleImage
+	record.ImageHash = hex.EncodeToString(imgHash.Sum(nil))
+	record.ImageName = fmt.Sprintf("ETL%s_0x%x_%s.png", strings.ToUpper(string(format)), record.JisCharacterCode, record.ImageHash)
+
+	return record, nil
+}
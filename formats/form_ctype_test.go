@@ -0,0 +1,130 @@
+package formats
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseCTypeRecord(t *testing.T) {
+	p := &bitPacker{}
+	p.put(0x987654321, 36) // Serial Data Number
+	p.put(1234, 36)        // Serial Sheet Number
+	p.put(0x41<<28, 36)    // JIS Code (上位8bit)
+	p.put(0xc1<<28, 36)    // EBCDIC Code (上位8bit)
+	// 4 Characters of Reading (上位24bitに6bitずつ)
+	p.put(1<<30|2<<24|3<<18|4<<12, 36)
+	for i := uint64(0); i < 15; i++ {
+		p.put(100+i, 36)
+	}
+	for i := 0; i < 1008/36; i++ {
+		p.put(0, 36) // undefined
+	}
+	for i := 0; i < cTypeSamplePixelNum; i++ {
+		p.put(uint64(i%16), cTypeSampleBitDepth)
+	}
+	if len(p.b) != cTypeRecordSize {
+		t.Fatalf("record size = %d, want %d", len(p.b), cTypeRecordSize)
+	}
+
+	record, err := parseCTypeRecord(bytes.NewReader(p.b), ETLFormat3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 36bitワードの下位32bitを格納する
+	if record.SerialDataNumber != 0x87654321 {
+		t.Errorf("SerialDataNumber = %#x, want 0x87654321", record.SerialDataNumber)
+	}
+	if record.SerialSheetNumber != 1234 {
+		t.Errorf("SerialSheetNumber = %d, want 1234", record.SerialSheetNumber)
+	}
+	if record.JisCharacterCode != 0x41 {
+		t.Errorf("JisCharacterCode = %#x, want 0x41", record.JisCharacterCode)
+	}
+	if record.EbcdicCharacterCode != 0xc1 {
+		t.Errorf("EbcdicCharacterCode = %#x, want 0xc1", record.EbcdicCharacterCode)
+	}
+	if want := [4]uint8{1, 2, 3, 4}; record.TypicalReading != want {
+		t.Errorf("TypicalReading = %v, want %v", record.TypicalReading, want)
+	}
+	if record.Character != "A" {
+		t.Errorf("Character = %q, want %q", record.Character, "A")
+	}
+
+	fields := []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{"QualityEvaluationOfIndividualCharacterImage", uint64(record.QualityEvaluationOfIndividualCharacterImage), 100},
+		{"QualityEvaluationOfCharacterGroup", uint64(record.QualityEvaluationOfCharacterGroup), 101},
+		{"YCoordinateOfSampleOnSheet", uint64(record.YCoordinateOfSampleOnSheet), 102},
+		{"XCoordinateOfSampleOnSheet", uint64(record.XCoordinateOfSampleOnSheet), 103},
+		{"GenderOfWriter", uint64(record.GenderOfWriter), 104},
+		{"AgeOfWriter", uint64(record.AgeOfWriter), 105},
+		{"IndustryClassificationCode", uint64(record.IndustryClassificationCode), 106},
+		{"OccupationClassificationCode", uint64(record.OccupationClassificationCode), 107},
+		{"DateOfCollection", uint64(record.DateOfCollection), 108},
+		{"DateOfScan", uint64(record.DateOfScan), 109},
+		{"NumberOfXAxisSamplingPoints", uint64(record.NumberOfXAxisSamplingPoints), 110},
+		{"NumberOfYAxisSamplingPoints", uint64(record.NumberOfYAxisSamplingPoints), 111},
+		{"NumberOfLevelsOfPixel", uint64(record.NumberOfLevelsOfPixel), 112},
+		{"MagnificationOfScanningLens", uint64(record.MagnificationOfScanningLens), 113},
+		{"SerialDataNumberOld", uint64(record.SerialDataNumberOld), 114},
+	}
+	for _, f := range fields {
+		if f.got != f.want {
+			t.Errorf("%s = %d, want %d", f.name, f.got, f.want)
+		}
+	}
+
+	// 4bitの画素は8bitになるよう左シフトする
+	checkGrayPixels(t, record.Image, cTypeSampleWidth, cTypeSampleHeight, func(i int) uint8 {
+		return uint8(i%16) << 4
+	})
+}
+
+func TestParseCTypeRecordUnmappedCode(t *testing.T) {
+	p := &bitPacker{}
+	p.put(0, 36)        // Serial Data Number
+	p.put(0, 36)        // Serial Sheet Number
+	p.put(0x80<<28, 36) // JIS Code. JIS X 0201にない文字コード
+	b := append(p.b, make([]byte, cTypeRecordSize-len(p.b))...)
+
+	record, err := parseCTypeRecord(bytes.NewReader(b), ETLFormat3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.JisCharacterCode != 0x80 {
+		t.Errorf("JisCharacterCode = %#x, want 0x80", record.JisCharacterCode)
+	}
+	if record.Character != "" {
+		t.Errorf("Character = %q, want empty", record.Character)
+	}
+}
+
+func TestParseCTypeRecordHiragana(t *testing.T) {
+	p := &bitPacker{}
+	p.put(0, 36)        // Serial Data Number
+	p.put(0, 36)        // Serial Sheet Number
+	p.put(0xb1<<28, 36) // JIS Code. ETL4のあはカタカナのアの文字コード
+	b := append(p.b, make([]byte, cTypeRecordSize-len(p.b))...)
+
+	// ETL4はひらがな, ETL5はカタカナとして変換する
+	cases := []struct {
+		format ETLFormat
+		want   string
+	}{
+		{ETLFormat4, "あ"},
+		{ETLFormat5, "ｱ"},
+	}
+	for _, c := range cases {
+		record, err := parseCTypeRecord(bytes.NewReader(b), c.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Character != c.want {
+			t.Errorf("ETL%s: Character = %q, want %q", c.format, record.Character, c.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package formats
+
+import "io"
+
+const (
+	// etl1RecordTotalNum レコード総数
+	etl1RecordTotalNum = 141319
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat1,
+		Name:           "ETL1",
+		RecordSize:     mTypeRecordSize,
+		RecordTotalNum: etl1RecordTotalNum,
+		FileNames:      numberedFileNames("ETL1C_%02d", 13),
+		Parse:          ParseETL1Record,
+	})
+}
+
+// RecordETL1 ETL1用レコード
+type RecordETL1 struct {
+	RecordMType
+}
+
+// ParseETL1Record M-type形式のETL1レコードをパーズする
+func ParseETL1Record(fp io.Reader) (Record, error) {
+	record, err := parseMTypeRecord(fp, ETLFormat1)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordETL1{record}, nil
+}
+
+// MakeETL1Datasets 指定ディレクトリに存在するすべてのETL1ファイルからデータセットを作成する
+// - inputDir: ETL1ファイルがあるディレクトリパス
+// - outputDir: ETL1のデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL1Datasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat1, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
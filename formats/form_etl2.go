@@ -0,0 +1,285 @@
+package formats
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path"
+
+	"github.com/PyYoshi/etlcdb-tools/tables"
+)
+
+const (
+	// etl2RecordSize レコードサイズ
+	etl2RecordSize = 2745
+
+	// etl2SampleWidth サンプリング画像の幅
+	etl2SampleWidth = 60
+
+	// etl2SampleHeight サンプリング画像の高さ
+	etl2SampleHeight = 60
+
+	// etl2SamplePixelNum サンプル画像ピクセル数
+	etl2SamplePixelNum = etl2SampleWidth * etl2SampleHeight
+
+	// etl2SampleBitDepth 1ピクセルあたりのbit数
+	etl2SampleBitDepth = 6
+
+	// etl2FileNum ファイル数
+	etl2FileNum = 5
+
+	// etl2RecordTotalNum レコード総数
+	etl2RecordTotalNum = 52796
+
+	// etl2CO59TableFileName CO-59の対応表ファイル名
+	etl2CO59TableFileName = "euc_co59.dat"
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat2,
+		Name:           "ETL2",
+		RecordSize:     etl2RecordSize,
+		RecordTotalNum: etl2RecordTotalNum,
+		FileNames:      numberedFileNames("ETL2_%d", etl2FileNum),
+		Parse:          ParseETL2Record,
+		Prepare:        prepareETL2,
+	})
+}
+
+// prepareETL2 inputDirにeuc_co59.datがある場合は, 組み込みの対応表の代わりにそれで文字コードを変換するパーザを返す
+// euc_co59.datがなく組み込みの対応表も空の場合は警告を出力する. その場合Characterは空文字
+// - inputDir: ETL2ファイルがあるディレクトリパス
+func prepareETL2(inputDir string) (func(fp io.Reader) (Record, error), error) {
+	fco59, err := os.Open(path.Join(inputDir, etl2CO59TableFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			if len(tables.CO59) == 0 {
+				log.Printf("ETL2: %s is not found in %s and the built-in CO-59 table is empty, characters will be empty\n", etl2CO59TableFileName, inputDir)
+			}
+			return ParseETL2Record, nil
+		}
+		return nil, err
+	}
+	defer fco59.Close()
+
+	co59, err := tables.ParseCO59(fco59)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fco59.Name(), err)
+	}
+	return etl2Parser(co59), nil
+}
+
+// etl2Parser co59で文字コードを変換するETL2のパーザ
+// - co59: CO-59コードからUnicodeへの対応表
+func etl2Parser(co59 map[uint16]uint16) func(fp io.Reader) (Record, error) {
+	return func(fp io.Reader) (Record, error) {
+		return parseETL2Record(fp, co59)
+	}
+}
+
+// RecordETL2 ETL2用レコード
+type RecordETL2 struct {
+	Format      ETLFormat   `json:"format"`
+	Character   string      `json:"character"`
+	Image       image.Image `json:"-"`
+	ImageHash   string      `json:"-"`
+	ImageName   string      `json:"image_name"`
+	ImageWidth  int         `json:"image_width"`
+	ImageHeight int         `json:"image_height"`
+
+	SerialDataNumber  uint64   `json:"serial_data_number"`
+	MarkOfStyle       uint8    `json:"mark_of_style"`
+	Contents          [6]uint8 `json:"contents"`
+	StyleOfCharacter  [6]uint8 `json:"style_of_character"`
+	CO59CharacterCode uint16   `json:"co59_character_code"`
+}
+
+// DeallocImage RecordETL2.Imageにnilを代入する
+func (r *RecordETL2) DeallocImage() {
+	r.Image = nil
+}
+
+// NewRecordETL2 RecordETL2を生成する
+// co59にない文字コードの場合Characterは空文字
+func NewRecordETL2(
+	serialDataNumber uint64,
+	markOfStyle uint8,
+	contents [6]uint8,
+	styleOfCharacter [6]uint8,
+	co59CharacterCode uint16,
+	img image.Image,
+	imgHash string,
+	co59 map[uint16]uint16,
+) RecordETL2 {
+	character := ""
+	if u, ok := co59[co59CharacterCode]; ok {
+		character = string(rune(u))
+	}
+
+	return RecordETL2{
+		Format:      ETLFormat2,
+		Character:   character,
+		Image:       img,
+		ImageHash:   imgHash,
+		ImageName:   fmt.Sprintf("ETL2_0x%x_%s.png", co59CharacterCode, imgHash),
+		ImageWidth:  etl2SampleWidth,
+		ImageHeight: etl2SampleHeight,
+
+		SerialDataNumber:  serialDataNumber,
+		MarkOfStyle:       markOfStyle,
+		Contents:          contents,
+		StyleOfCharacter:  styleOfCharacter,
+		CO59CharacterCode: co59CharacterCode,
+	}
+}
+
+// GetKey ETL2レコード全体でユニークなキー
+func (r *RecordETL2) GetKey() string {
+	return r.ImageName
+}
+
+// SetKey レコードのキー(画像ファイル名)を変更する
+func (r *RecordETL2) SetKey(key string) {
+	r.ImageName = key
+}
+
+// GetImage レコードに格納された画像. DeallocImage後はnil
+func (r *RecordETL2) GetImage() image.Image {
+	return r.Image
+}
+
+// GetBitDepth 画像の元の1ピクセルあたりのbit数
+func (r *RecordETL2) GetBitDepth() int {
+	return etl2SampleBitDepth
+}
+
+// GetCharacter レコードの文字
+func (r *RecordETL2) GetCharacter() string {
+	return r.Character
+}
+
+// GetCharacterCode レコードの文字コード(CO-59)
+func (r *RecordETL2) GetCharacterCode() uint16 {
+	return r.CO59CharacterCode
+}
+
+// ParseETL2Record K-type形式のETL2レコードをパーズする
+// 各フィールドは36bitワード単位で6bitずつ詰められている
+// Characterは組み込みの対応表 tables.CO59 で求める. 対応表にない文字コードの場合は空文字
+// euc_co59.datで変換する場合はOpenETLFileやMakeDatasetsを使うこと
+func ParseETL2Record(fp io.Reader) (Record, error) {
+	return parseETL2Record(fp, tables.CO59)
+}
+
+// parseETL2Record ETL2レコードをパーズし, co59で文字コードを変換する
+func parseETL2Record(fp io.Reader, co59 map[uint16]uint16) (Record, error) {
+	var err error
+
+	rb := make([]byte, etl2RecordSize)
+	_, err = io.ReadFull(fp, rb)
+	if err != nil {
+		return nil, err
+	}
+	br := NewBitReader(bytes.NewReader(rb))
+
+	serialDataNumber, err := br.ReadUint(36)
+	if err != nil {
+		return nil, err
+	}
+
+	markOfStyle, err := br.ReadUint(6)
+	if err != nil {
+		return nil, err
+	}
+
+	// spaces
+	_, err = br.ReadUint(30)
+	if err != nil {
+		return nil, err
+	}
+
+	var contents [6]uint8
+	for i := range contents {
+		c, err := br.ReadUint(6)
+		if err != nil {
+			return nil, err
+		}
+		contents[i] = uint8(c)
+	}
+
+	var styleOfCharacter [6]uint8
+	for i := range styleOfCharacter {
+		c, err := br.ReadUint(6)
+		if err != nil {
+			return nil, err
+		}
+		styleOfCharacter[i] = uint8(c)
+	}
+
+	// zero
+	_, err = br.ReadUint(24)
+	if err != nil {
+		return nil, err
+	}
+
+	co59Hi, err := br.ReadUint(6)
+	if err != nil {
+		return nil, err
+	}
+
+	co59Lo, err := br.ReadUint(6)
+	if err != nil {
+		return nil, err
+	}
+
+	// undefined
+	for i := 0; i < 180/36; i++ {
+		_, err = br.ReadUint(36)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sampleImage := image.NewGray(image.Rect(0, 0, etl2SampleWidth, etl2SampleHeight))
+	for i := 0; i < etl2SamplePixelNum; i++ {
+		px, err := br.ReadUint(etl2SampleBitDepth)
+		if err != nil {
+			return nil, err
+		}
+
+		// 6bitグレイスケールを8bitへ
+		sampleImage.Pix[i] = uint8(px) * (256 / 64)
+	}
+
+	imgHash := sha256.New()
+	imgHash.Write(sampleImage.Pix)
+
+	record := NewRecordETL2(
+		serialDataNumber,
+		uint8(markOfStyle),
+		contents,
+		styleOfCharacter,
+		tables.CO59Code(uint8(co59Hi), uint8(co59Lo)),
+		sampleImage,
+		hex.EncodeToString(imgHash.Sum(nil)),
+		co59,
+	)
+
+	return &record, nil
+}
+
+// MakeETL2Datasets 指定ディレクトリに存在するすべてのETL2ファイルからデータセットを作成する
+// - inputDir: ETL2ファイルがあるディレクトリパス. euc_co59.datがある場合は文字コードの変換に使う. ない場合は組み込みの対応表を使う
+// - outputDir: ETL2のデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL2Datasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat2, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
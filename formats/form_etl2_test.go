@@ -0,0 +1,167 @@
+package formats
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/PyYoshi/etlcdb-tools/tables"
+)
+
+// bitPacker テスト用のレコードを上位bitから詰めて組み立てる
+type bitPacker struct {
+	b []byte
+	n uint
+}
+
+// put vの下位nbits bitを上位bitから順に書き込む
+func (p *bitPacker) put(v uint64, nbits uint) {
+	for i := nbits; i > 0; i-- {
+		if p.n%8 == 0 {
+			p.b = append(p.b, 0)
+		}
+		if v>>(i-1)&1 == 1 {
+			p.b[len(p.b)-1] |= 0x80 >> (p.n % 8)
+		}
+		p.n++
+	}
+}
+
+// checkGrayPixels 画像の画素がwantと一致するか確認する
+func checkGrayPixels(t *testing.T, img image.Image, width, height int, want func(i int) uint8) {
+	t.Helper()
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("image is %T, want *image.Gray", img)
+	}
+	if gray.Rect.Dx() != width || gray.Rect.Dy() != height {
+		t.Fatalf("image size = %v, want %dx%d", gray.Rect.Size(), width, height)
+	}
+	for i, v := range gray.Pix {
+		if v != want(i) {
+			t.Fatalf("pixel %d = %#x, want %#x", i, v, want(i))
+		}
+	}
+}
+
+// etl2TestRecord テスト用のETL2のレコードを生成する. 画素はi番目が i%64
+// - hi, lo: CO-59コードの上位6bitと下位6bit
+func etl2TestRecord(t *testing.T, hi, lo uint64) []byte {
+	t.Helper()
+	p := &bitPacker{}
+	p.put(0x123456789, 36) // Serial Data Number
+	p.put(5, 6)            // Mark of Style
+	p.put(0, 30)           // spaces
+	for i := uint64(0); i < 6; i++ {
+		p.put(10+i, 6) // Contents
+	}
+	for i := uint64(0); i < 6; i++ {
+		p.put(20+i, 6) // Style of Character
+	}
+	p.put(0, 24)  // zero
+	p.put(hi, 6)  // CO-59 上位6bit
+	p.put(lo, 6)  // CO-59 下位6bit
+	p.put(0, 180) // undefined
+	for i := 0; i < etl2SamplePixelNum; i++ {
+		p.put(uint64(i%64), etl2SampleBitDepth)
+	}
+	if len(p.b) != etl2RecordSize {
+		t.Fatalf("record size = %d, want %d", len(p.b), etl2RecordSize)
+	}
+	return p.b
+}
+
+func TestParseETL2Record(t *testing.T) {
+	// 亜(EUC-JP 0xb0a1)をCO-59の(16, 33)とする
+	co59, err := tables.ParseCO59(strings.NewReader("A:1,2 \xb0\xa1:16,33"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := etl2Parser(co59)(bytes.NewReader(etl2TestRecord(t, 16, 33)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := r.(*RecordETL2)
+
+	if record.SerialDataNumber != 0x123456789 {
+		t.Errorf("SerialDataNumber = %#x, want 0x123456789", record.SerialDataNumber)
+	}
+	if record.MarkOfStyle != 5 {
+		t.Errorf("MarkOfStyle = %d, want 5", record.MarkOfStyle)
+	}
+	if want := [6]uint8{10, 11, 12, 13, 14, 15}; record.Contents != want {
+		t.Errorf("Contents = %v, want %v", record.Contents, want)
+	}
+	if want := [6]uint8{20, 21, 22, 23, 24, 25}; record.StyleOfCharacter != want {
+		t.Errorf("StyleOfCharacter = %v, want %v", record.StyleOfCharacter, want)
+	}
+	if want := tables.CO59Code(16, 33); record.CO59CharacterCode != want {
+		t.Errorf("CO59CharacterCode = %#x, want %#x", record.CO59CharacterCode, want)
+	}
+	if record.Character != "亜" {
+		t.Errorf("Character = %q, want %q", record.Character, "亜")
+	}
+
+	// 6bitの画素は8bitになるよう左シフトする
+	checkGrayPixels(t, record.Image, etl2SampleWidth, etl2SampleHeight, func(i int) uint8 {
+		return uint8(i%64) << 2
+	})
+}
+
+func TestOpenETL2FileCO59Table(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etl2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fpath := path.Join(dir, "ETL2_1")
+	err = ioutil.WriteFile(fpath, etl2TestRecord(t, 16, 33), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readCharacter := func() string {
+		t.Helper()
+		it, err := OpenETLFile(fpath, ETLFormat2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		if !it.Next() {
+			t.Fatalf("Next = false: %v", it.Err())
+		}
+		return it.Record().GetCharacter()
+	}
+
+	// euc_co59.datがない場合は組み込みの対応表で変換する. 対応表にない場合は空文字
+	want := ""
+	if u, ok := tables.CO59[tables.CO59Code(16, 33)]; ok {
+		want = string(rune(u))
+	}
+	if got := readCharacter(); got != want {
+		t.Errorf("without %s: Character = %q, want %q", etl2CO59TableFileName, got, want)
+	}
+
+	// 入力ディレクトリのeuc_co59.datで変換する
+	err = ioutil.WriteFile(path.Join(dir, etl2CO59TableFileName), []byte("\xb0\xa1:16,33\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readCharacter(); got != "亜" {
+		t.Errorf("with %s: Character = %q, want %q", etl2CO59TableFileName, got, "亜")
+	}
+
+	// 壊れたeuc_co59.datはエラーにする
+	err = ioutil.WriteFile(path.Join(dir, etl2CO59TableFileName), []byte("broken"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenETLFile(fpath, ETLFormat2); err == nil {
+		t.Errorf("OpenETLFile with a broken %s = nil, want an error", etl2CO59TableFileName)
+	}
+}
@@ -0,0 +1,43 @@
+package formats
+
+import "io"
+
+const (
+	// etl3RecordTotalNum レコード総数
+	etl3RecordTotalNum = 9600
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat3,
+		Name:           "ETL3",
+		RecordSize:     cTypeRecordSize,
+		RecordTotalNum: etl3RecordTotalNum,
+		FileNames:      []string{"ETL3C_1", "ETL3C_2"},
+		Parse:          ParseETL3Record,
+	})
+}
+
+// RecordETL3 ETL3用レコード
+type RecordETL3 struct {
+	RecordCType
+}
+
+// ParseETL3Record C-type形式のETL3レコードをパーズする
+func ParseETL3Record(fp io.Reader) (Record, error) {
+	record, err := parseCTypeRecord(fp, ETLFormat3)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordETL3{record}, nil
+}
+
+// MakeETL3Datasets 指定ディレクトリに存在するすべてのETL3ファイルからデータセットを作成する
+// - inputDir: ETL3ファイルがあるディレクトリパス
+// - outputDir: ETL3のデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL3Datasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat3, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
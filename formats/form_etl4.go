@@ -0,0 +1,44 @@
+package formats
+
+import "io"
+
+const (
+	// etl4RecordTotalNum レコード総数
+	etl4RecordTotalNum = 6120
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat4,
+		Name:           "ETL4",
+		RecordSize:     cTypeRecordSize,
+		RecordTotalNum: etl4RecordTotalNum,
+		FileNames:      []string{"ETL4C"},
+		Parse:          ParseETL4Record,
+	})
+}
+
+// RecordETL4 ETL4用レコード
+type RecordETL4 struct {
+	RecordCType
+}
+
+// ParseETL4Record C-type形式のETL4レコードをパーズする
+// ETL4はひらがなをJIS X 0201のカタカナの文字コードで格納しているため, Characterはひらがなにする
+func ParseETL4Record(fp io.Reader) (Record, error) {
+	record, err := parseCTypeRecord(fp, ETLFormat4)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordETL4{record}, nil
+}
+
+// MakeETL4Datasets 指定ディレクトリに存在するすべてのETL4ファイルからデータセットを作成する
+// - inputDir: ETL4ファイルがあるディレクトリパス
+// - outputDir: ETL4のデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL4Datasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat4, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
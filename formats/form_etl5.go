@@ -0,0 +1,43 @@
+package formats
+
+import "io"
+
+const (
+	// etl5RecordTotalNum レコード総数
+	etl5RecordTotalNum = 10608
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat5,
+		Name:           "ETL5",
+		RecordSize:     cTypeRecordSize,
+		RecordTotalNum: etl5RecordTotalNum,
+		FileNames:      []string{"ETL5C"},
+		Parse:          ParseETL5Record,
+	})
+}
+
+// RecordETL5 ETL5用レコード
+type RecordETL5 struct {
+	RecordCType
+}
+
+// ParseETL5Record C-type形式のETL5レコードをパーズする
+func ParseETL5Record(fp io.Reader) (Record, error) {
+	record, err := parseCTypeRecord(fp, ETLFormat5)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordETL5{record}, nil
+}
+
+// MakeETL5Datasets 指定ディレクトリに存在するすべてのETL5ファイルからデータセットを作成する
+// - inputDir: ETL5ファイルがあるディレクトリパス
+// - outputDir: ETL5のデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL5Datasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat5, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
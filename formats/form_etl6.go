@@ -0,0 +1,43 @@
+package formats
+
+import "io"
+
+const (
+	// etl6RecordTotalNum レコード総数
+	etl6RecordTotalNum = 157662
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat6,
+		Name:           "ETL6",
+		RecordSize:     mTypeRecordSize,
+		RecordTotalNum: etl6RecordTotalNum,
+		FileNames:      numberedFileNames("ETL6C_%02d", 12),
+		Parse:          ParseETL6Record,
+	})
+}
+
+// RecordETL6 ETL6用レコード
+type RecordETL6 struct {
+	RecordMType
+}
+
+// ParseETL6Record M-type形式のETL6レコードをパーズする
+func ParseETL6Record(fp io.Reader) (Record, error) {
+	record, err := parseMTypeRecord(fp, ETLFormat6)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordETL6{record}, nil
+}
+
+// MakeETL6Datasets 指定ディレクトリに存在するすべてのETL6ファイルからデータセットを作成する
+// - inputDir: ETL6ファイルがあるディレクトリパス
+// - outputDir: ETL6のデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL6Datasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat6, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
@@ -0,0 +1,44 @@
+package formats
+
+import "io"
+
+const (
+	// etl7RecordTotalNum レコード総数
+	etl7RecordTotalNum = 16800
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat7,
+		Name:           "ETL7",
+		RecordSize:     mTypeRecordSize,
+		RecordTotalNum: etl7RecordTotalNum,
+		FileNames:      []string{"ETL7LC_1", "ETL7LC_2", "ETL7SC_1", "ETL7SC_2"},
+		Parse:          ParseETL7Record,
+	})
+}
+
+// RecordETL7 ETL7用レコード
+type RecordETL7 struct {
+	RecordMType
+}
+
+// ParseETL7Record M-type形式のETL7レコードをパーズする
+// ETL7はひらがなをJIS X 0201のカタカナの文字コードで格納しているため, Characterはひらがなにする
+func ParseETL7Record(fp io.Reader) (Record, error) {
+	record, err := parseMTypeRecord(fp, ETLFormat7)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordETL7{record}, nil
+}
+
+// MakeETL7Datasets 指定ディレクトリに存在するすべてのETL7ファイルからデータセットを作成する
+// - inputDir: ETL7ファイルがあるディレクトリパス
+// - outputDir: ETL7のデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL7Datasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat7, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
@@ -0,0 +1,47 @@
+package formats
+
+import "io"
+
+const (
+	// etl8bRecordSize レコードサイズ
+	etl8bRecordSize = 512
+
+	// etl8bRecordTotalNum レコード総数
+	etl8bRecordTotalNum = 152960
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat8b,
+		Name:           "ETL8B",
+		RecordSize:     etl8bRecordSize,
+		RecordTotalNum: etl8bRecordTotalNum,
+		DummyRecordNum: 1,
+		FileNames:      []string{"ETL8B2C1", "ETL8B2C2", "ETL8B2C3"},
+		Parse:          ParseETL8BRecord,
+	})
+}
+
+// RecordETL8B ETL8B用レコード
+type RecordETL8B struct {
+	RecordBinary
+}
+
+// ParseETL8BRecord ETL8Bレコードをパーズする
+func ParseETL8BRecord(fp io.Reader) (Record, error) {
+	record, err := parseBinaryRecord(fp, ETLFormat8b, etl8bRecordSize)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordETL8B{record}, nil
+}
+
+// MakeETL8BDatasets 指定ディレクトリに存在するすべてのETL8Bファイルからデータセットを作成する
+// - inputDir: ETL8Bファイルがあるディレクトリパス
+// - outputDir: ETL8Bのデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL8BDatasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat8b, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
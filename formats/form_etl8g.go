@@ -1,26 +1,15 @@
 package formats
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"image"
-	"image/png"
 	"io"
-	"log"
-	"os"
-	"path"
 	"strings"
-	"sync"
 
 	"github.com/PyYoshi/etlcdb-tools/tables"
-	"github.com/PyYoshi/etlcdb-tools/utils"
-	"github.com/disintegration/imaging"
-	"github.com/syndtr/goleveldb/leveldb"
 )
 
 const (
@@ -33,6 +22,9 @@ const (
 	// etl8gSampleHeight サンプリング画像の高さ
 	etl8gSampleHeight = 127
 
+	// etl8gSampleBitDepth 1ピクセルあたりのbit数
+	etl8gSampleBitDepth = 4
+
 	// etl8gSamplePixelNum サンプル画像ピクセル数
 	etl8gSamplePixelNum = etl8gSampleWidth * etl8gSampleHeight
 
@@ -49,6 +41,17 @@ const (
 	etl8gRecordTotalNum = (etl8gRecordNum * (etl8gFileNum - 1)) + 956
 )
 
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat8g,
+		Name:           "ETL8G",
+		RecordSize:     etl8gRecordSize,
+		RecordTotalNum: etl8gRecordTotalNum,
+		FileNames:      numberedFileNames("ETL8G_%02d", etl8gFileNum),
+		Parse:          ParseETL8GRecord,
+	})
+}
+
 // RecordETL8G ETL8G用レコード
 // http://etlcdb.db.aist.go.jp/?page_id=2461
 type RecordETL8G struct {
@@ -82,6 +85,7 @@ func (r *RecordETL8G) DeallocImage() {
 }
 
 // NewRecordETL8G RecordETL8Gを生成する
+// JIS X 0208にない文字コードの場合Characterは空文字
 func NewRecordETL8G(
 	serialSheetNumber uint16,
 	jisCharacterCode uint16,
@@ -100,9 +104,14 @@ func NewRecordETL8G(
 	img image.Image,
 	imgHash string,
 ) RecordETL8G {
+	character := ""
+	if u, ok := tables.JIS0208[jisCharacterCode]; ok {
+		character = string(rune(u))
+	}
+
 	return RecordETL8G{
 		Format:      ETLFormat8g,
-		Character:   string(tables.JIS0208[jisCharacterCode]),
+		Character:   character,
 		Image:       img,
 		ImageHash:   imgHash,
 		ImageName:   fmt.Sprintf("ETL8G_0x%x_%s.png", jisCharacterCode, imgHash),
@@ -126,29 +135,34 @@ func NewRecordETL8G(
 	}
 }
 
-// OutputImage レコードに格納された画像任意のディレクトリへ出力する
-// - outputDir: 出力するディレクトリパス
-// - width: 出力する画像の幅
-// - height: 出力する画像の高さ
-func (r *RecordETL8G) OutputImage(outputDir string, width, height int) error {
-	if r.Image == nil {
-		return errors.New("RecordETL8G.Image is nil")
-	}
+// GetKey ETL8Gレコード全体でユニークなキー
+func (r *RecordETL8G) GetKey() string {
+	return r.ImageName
+}
 
-	// リサイズ
-	var dstImage image.Image
-	if !(width == etl8gSampleWidth && height == etl8gSampleHeight) {
-		dstImage = imaging.Resize(r.Image, width, height, imaging.Lanczos)
-	} else {
-		dstImage = r.Image
-	}
+// SetKey レコードのキー(画像ファイル名)を変更する
+func (r *RecordETL8G) SetKey(key string) {
+	r.ImageName = key
+}
 
-	return outputPng(outputDir, r.ImageName, dstImage, png.BestCompression)
+// GetImage レコードに格納された画像. DeallocImage後はnil
+func (r *RecordETL8G) GetImage() image.Image {
+	return r.Image
 }
 
-// GetKey ETL8Gレコード全体でユニークなキー
-func (r *RecordETL8G) GetKey() string {
-	return r.ImageName
+// GetBitDepth 画像の元の1ピクセルあたりのbit数
+func (r *RecordETL8G) GetBitDepth() int {
+	return etl8gSampleBitDepth
+}
+
+// GetCharacter レコードの文字
+func (r *RecordETL8G) GetCharacter() string {
+	return r.Character
+}
+
+// GetCharacterCode レコードの文字コード(JIS X 0208)
+func (r *RecordETL8G) GetCharacterCode() uint16 {
+	return r.JisCharacterCode
 }
 
 func ParseETL8GRecord(fp io.Reader) (Record, error) {
@@ -301,74 +315,6 @@ func ParseETL8GRecord(fp io.Reader) (Record, error) {
 	return &record, nil
 }
 
-type jobWorkerMakeETL8GDatasets struct {
-	outputDir                           string
-	outputImageWidth, outputImageHeight int
-	ldb                                 *leveldb.DB
-	mu                                  *sync.Mutex
-}
-
-func (w *jobWorkerMakeETL8GDatasets) start(wg *sync.WaitGroup, q chan string) {
-	defer wg.Done()
-	for {
-		fpath, ok := <-q // closeされると ok が false になる
-		if !ok {
-			return
-		}
-
-		log.Printf("ETL8G: reading %s\n", fpath)
-
-		// ファイルを開く
-		r, err := os.Open(fpath)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		ldbBatch := new(leveldb.Batch)
-		for i := 0; i < etl8gRecordNum; i++ {
-			// レコードサイズ分メモリへ読み込み, そこから処理を行う
-			rb := make([]byte, etl8gRecordSize)
-			_, err = r.Read(rb)
-			if err != nil {
-				if err == io.EOF {
-					break
-				} else {
-					log.Fatal(err)
-				}
-			}
-			r2 := bytes.NewReader(rb)
-
-			record, err := ParseETL8GRecord(r2)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			// 画像を生成
-			err = record.OutputImage(w.outputDir, w.outputImageWidth, w.outputImageHeight)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			// DeallocImageを逐一呼び出ししないとメモリ不足で落ちる
-			record.DeallocImage()
-
-			rjb, err := json.Marshal(record)
-			if err != nil {
-				log.Fatal(err)
-			}
-			ldbBatch.Put([]byte(record.GetKey()), rjb)
-		}
-		r.Close()
-
-		w.mu.Lock()
-		err = w.ldb.Write(ldbBatch, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		w.mu.Unlock()
-	}
-}
-
 // MakeETL8GDatasets 指定ディレクトリに存在するすべてのETL8Gファイルからデータセットを作成する
 // - inputDir: ETL8Gファイルがあるディレクトリパス
 // - outputDir: ETL8Gのデータセットを出力するディレクトリパス
@@ -376,97 +322,5 @@ func (w *jobWorkerMakeETL8GDatasets) start(wg *sync.WaitGroup, q chan string) {
 // - outputImageHeight: 出力する画像の高さ
 // - workerNum: 並行して実行する数
 func MakeETL8GDatasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
-	err := utils.CreateIfNotExists(outputDir, true)
-	if err != nil {
-		return err
-	}
-
-	ldbPath := path.Join(outputDir, ".ldb")
-	err = utils.CreateIfNotExists(ldbPath, true)
-	if err != nil {
-		return err
-	}
-
-	ldb, err := leveldb.OpenFile(ldbPath, nil)
-	if err != nil {
-		return err
-	}
-
-	jobWorker := jobWorkerMakeETL8GDatasets{
-		outputDir:         outputDir,
-		outputImageWidth:  outputImageWidth,
-		outputImageHeight: outputImageHeight,
-		ldb:               ldb,
-		mu:                &sync.Mutex{},
-	}
-
-	q := make(chan string, etl8gFileNum)
-
-	wg := &sync.WaitGroup{}
-	for i := 0; i < workerNum; i++ {
-		wg.Add(1)
-		go jobWorker.start(wg, q)
-	}
-
-	for i := 1; i <= etl8gFileNum; i++ {
-		q <- path.Join(inputDir, fmt.Sprintf("ETL8G_%02d", i))
-	}
-	close(q)
-
-	// 処理待ち
-	wg.Wait()
-
-	// etl8g.json用io.Writer
-	wj, err := os.Create(path.Join(outputDir, "etl8g.json"))
-	if err != nil {
-		return err
-	}
-
-	// etl8g.json先頭に`[`を付加
-	_, err = wj.Write([]byte("[\n"))
-	if err != nil {
-		return err
-	}
-
-	ldbIter := ldb.NewIterator(nil, nil)
-	ldbIterIndex := 0
-	for ldbIter.Next() {
-		rjb := ldbIter.Value()
-		_, err = wj.Write(rjb)
-		if err != nil {
-			return err
-		}
-
-		// 最後のレコードの場合はカンマを付けないように
-		if ldbIterIndex < etl8gRecordTotalNum-1 {
-			_, err = wj.Write([]byte(",\n"))
-			if err != nil {
-				return err
-			}
-		} else {
-			_, err = wj.Write([]byte("\n"))
-			if err != nil {
-				return err
-			}
-		}
-		ldbIterIndex++
-	}
-	ldbIter.Release()
-	err = ldbIter.Error()
-	if err != nil {
-		return err
-	}
-
-	// etl8g.json終端に`[`を付加
-	_, err = wj.Write([]byte("]"))
-	if err != nil {
-		return err
-	}
-
-	// etl8g.json用io.Writerを閉じる
-	wj.Close()
-
-	// leveldbで利用したファイルを削除
-	ldb.Close()
-	return os.RemoveAll(ldbPath)
+	return MakeDatasets(ETLFormat8g, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
 }
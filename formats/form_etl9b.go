@@ -0,0 +1,47 @@
+package formats
+
+import "io"
+
+const (
+	// etl9bRecordSize レコードサイズ
+	etl9bRecordSize = 576
+
+	// etl9bRecordTotalNum レコード総数
+	etl9bRecordTotalNum = 607200
+)
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat9b,
+		Name:           "ETL9B",
+		RecordSize:     etl9bRecordSize,
+		RecordTotalNum: etl9bRecordTotalNum,
+		DummyRecordNum: 1,
+		FileNames:      numberedFileNames("ETL9B_%d", 5),
+		Parse:          ParseETL9BRecord,
+	})
+}
+
+// RecordETL9B ETL9B用レコード
+type RecordETL9B struct {
+	RecordBinary
+}
+
+// ParseETL9BRecord ETL9Bレコードをパーズする
+func ParseETL9BRecord(fp io.Reader) (Record, error) {
+	record, err := parseBinaryRecord(fp, ETLFormat9b, etl9bRecordSize)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordETL9B{record}, nil
+}
+
+// MakeETL9BDatasets 指定ディレクトリに存在するすべてのETL9Bファイルからデータセットを作成する
+// - inputDir: ETL9Bファイルがあるディレクトリパス
+// - outputDir: ETL9Bのデータセットを出力するディレクトリパス
+// - outputImageWidth: 出力する画像の幅
+// - outputImageHeight: 出力する画像の高さ
+// - workerNum: 並行して実行する数
+func MakeETL9BDatasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
+	return MakeDatasets(ETLFormat9b, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
+}
@@ -1,28 +1,15 @@
 package formats
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"image"
-	"image/png"
 	"io"
-	"log"
-	"os"
-	"path"
 	"strings"
-	"sync"
 
 	"github.com/PyYoshi/etlcdb-tools/tables"
-	"github.com/PyYoshi/etlcdb-tools/utils"
-	"github.com/syndtr/goleveldb/leveldb"
-
-	"encoding/json"
-
-	"github.com/disintegration/imaging"
 )
 
 const (
@@ -35,6 +22,9 @@ const (
 	// etl9gSampleHeight サンプリング画像の高さ
 	etl9gSampleHeight = 127
 
+	// etl9gSampleBitDepth 1ピクセルあたりのbit数
+	etl9gSampleBitDepth = 4
+
 	// etl9gSamplePixelNum サンプル画像ピクセル数
 	etl9gSamplePixelNum = etl9gSampleWidth * etl9gSampleHeight
 
@@ -51,6 +41,17 @@ const (
 	etl9gRecordTotalNum = etl9gRecordNum * etl9gFileNum
 )
 
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         ETLFormat9g,
+		Name:           "ETL9G",
+		RecordSize:     etl9gRecordSize,
+		RecordTotalNum: etl9gRecordTotalNum,
+		FileNames:      numberedFileNames("ETL9G_%02d", etl9gFileNum),
+		Parse:          ParseETL9GRecord,
+	})
+}
+
 // RecordETL9G ETL9G用レコード
 // http://etlcdb.db.aist.go.jp/?page_id=1711
 type RecordETL9G struct {
@@ -84,6 +85,7 @@ func (r *RecordETL9G) DeallocImage() {
 }
 
 // NewRecordETL9G RecordETL9Gを生成する
+// JIS X 0208にない文字コードの場合Characterは空文字
 func NewRecordETL9G(
 	serialSheetNumber uint16,
 	jisCharacterCode uint16,
@@ -102,9 +104,14 @@ func NewRecordETL9G(
 	img image.Image,
 	imgHash string,
 ) RecordETL9G {
+	character := ""
+	if u, ok := tables.JIS0208[jisCharacterCode]; ok {
+		character = string(rune(u))
+	}
+
 	return RecordETL9G{
 		Format:      ETLFormat9g,
-		Character:   string(tables.JIS0208[jisCharacterCode]),
+		Character:   character,
 		Image:       img,
 		ImageHash:   imgHash,
 		ImageName:   fmt.Sprintf("ETL9G_0x%x_%s.png", jisCharacterCode, imgHash),
@@ -128,29 +135,34 @@ func NewRecordETL9G(
 	}
 }
 
-// OutputImage レコードに格納された画像任意のディレクトリへ出力する
-// - outputDir: 出力するディレクトリパス
-// - width: 出力する画像の幅
-// - height: 出力する画像の高さ
-func (r *RecordETL9G) OutputImage(outputDir string, width, height int) error {
-	if r.Image == nil {
-		return errors.New("RecordETL9G.Image is nil")
-	}
+// GetKey ETL9Gレコード全体でユニークなキー
+func (r *RecordETL9G) GetKey() string {
+	return r.ImageName
+}
 
-	// リサイズ
-	var dstImage image.Image
-	if !(width == etl9gSampleWidth && height == etl9gSampleHeight) {
-		dstImage = imaging.Resize(r.Image, width, height, imaging.Lanczos)
-	} else {
-		dstImage = r.Image
-	}
+// SetKey レコードのキー(画像ファイル名)を変更する
+func (r *RecordETL9G) SetKey(key string) {
+	r.ImageName = key
+}
 
-	return outputPng(outputDir, r.ImageName, dstImage, png.BestCompression)
+// GetImage レコードに格納された画像. DeallocImage後はnil
+func (r *RecordETL9G) GetImage() image.Image {
+	return r.Image
 }
 
-// GetKey ETL9Gレコード全体でユニークなキー
-func (r *RecordETL9G) GetKey() string {
-	return r.ImageName
+// GetBitDepth 画像の元の1ピクセルあたりのbit数
+func (r *RecordETL9G) GetBitDepth() int {
+	return etl9gSampleBitDepth
+}
+
+// GetCharacter レコードの文字
+func (r *RecordETL9G) GetCharacter() string {
+	return r.Character
+}
+
+// GetCharacterCode レコードの文字コード(JIS X 0208)
+func (r *RecordETL9G) GetCharacterCode() uint16 {
+	return r.JisCharacterCode
 }
 
 func ParseETL9GRecord(fp io.Reader) (Record, error) {
@@ -303,70 +315,6 @@ func ParseETL9GRecord(fp io.Reader) (Record, error) {
 	return &record, nil
 }
 
-type jobWorkerMakeETL9GDatasets struct {
-	outputDir                           string
-	outputImageWidth, outputImageHeight int
-	ldb                                 *leveldb.DB
-	mu                                  *sync.Mutex
-}
-
-func (w *jobWorkerMakeETL9GDatasets) start(wg *sync.WaitGroup, q chan string) {
-	defer wg.Done()
-	for {
-		fpath, ok := <-q // closeされると ok が false になる
-		if !ok {
-			return
-		}
-
-		log.Printf("ETL9G: reading %s\n", fpath)
-
-		// ファイルを開く
-		r, err := os.Open(fpath)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		ldbBatch := new(leveldb.Batch)
-		for i := 0; i < etl9gRecordNum; i++ {
-			// レコードサイズ分メモリへ読み込み, そこから処理を行う
-			rb := make([]byte, etl9gRecordSize)
-			_, err = r.Read(rb)
-			if err != nil {
-				log.Fatal(err)
-			}
-			r2 := bytes.NewReader(rb)
-
-			record, err := ParseETL9GRecord(r2)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			// 画像を生成
-			err = record.OutputImage(w.outputDir, w.outputImageWidth, w.outputImageHeight)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			// DeallocImageを逐一呼び出ししないとメモリ不足で落ちる
-			record.DeallocImage()
-
-			rjb, err := json.Marshal(record)
-			if err != nil {
-				log.Fatal(err)
-			}
-			ldbBatch.Put([]byte(record.GetKey()), rjb)
-		}
-		r.Close()
-
-		w.mu.Lock()
-		err = w.ldb.Write(ldbBatch, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		w.mu.Unlock()
-	}
-}
-
 // MakeETL9GDatasets 指定ディレクトリに存在するすべてのETL9Gファイルからデータセットを作成する
 // - inputDir: ETL9Gファイルがあるディレクトリパス
 // - outputDir: ETL9Gのデータセットを出力するディレクトリパス
@@ -374,97 +322,5 @@ func (w *jobWorkerMakeETL9GDatasets) start(wg *sync.WaitGroup, q chan string) {
 // - outputImageHeight: 出力する画像の高さ
 // - workerNum: 並行して実行する数
 func MakeETL9GDatasets(inputDir, outputDir string, outputImageWidth, outputImageHeight, workerNum int) error {
-	err := utils.CreateIfNotExists(outputDir, true)
-	if err != nil {
-		return err
-	}
-
-	ldbPath := path.Join(outputDir, ".ldb")
-	err = utils.CreateIfNotExists(ldbPath, true)
-	if err != nil {
-		return err
-	}
-
-	ldb, err := leveldb.OpenFile(ldbPath, nil)
-	if err != nil {
-		return err
-	}
-
-	jobWorker := jobWorkerMakeETL9GDatasets{
-		outputDir:         outputDir,
-		outputImageWidth:  outputImageWidth,
-		outputImageHeight: outputImageHeight,
-		ldb:               ldb,
-		mu:                &sync.Mutex{},
-	}
-
-	q := make(chan string, etl9gFileNum)
-
-	wg := &sync.WaitGroup{}
-	for i := 0; i < workerNum; i++ {
-		wg.Add(1)
-		go jobWorker.start(wg, q)
-	}
-
-	for i := 1; i <= etl9gFileNum; i++ {
-		q <- path.Join(inputDir, fmt.Sprintf("ETL9G_%02d", i))
-	}
-	close(q)
-
-	// 処理待ち
-	wg.Wait()
-
-	// etl9g.json用io.Writer
-	wj, err := os.Create(path.Join(outputDir, "etl9g.json"))
-	if err != nil {
-		return err
-	}
-
-	// etl9g.json先頭に`[`を付加
-	_, err = wj.Write([]byte("[\n"))
-	if err != nil {
-		return err
-	}
-
-	ldbIter := ldb.NewIterator(nil, nil)
-	ldbIterIndex := 0
-	for ldbIter.Next() {
-		rjb := ldbIter.Value()
-		_, err = wj.Write(rjb)
-		if err != nil {
-			return err
-		}
-
-		// 最後のレコードの場合はカンマを付けないように
-		if ldbIterIndex < etl9gRecordTotalNum-1 {
-			_, err = wj.Write([]byte(",\n"))
-			if err != nil {
-				return err
-			}
-		} else {
-			_, err = wj.Write([]byte("\n"))
-			if err != nil {
-				return err
-			}
-		}
-		ldbIterIndex++
-	}
-	ldbIter.Release()
-	err = ldbIter.Error()
-	if err != nil {
-		return err
-	}
-
-	// etl9g.json終端に`[`を付加
-	_, err = wj.Write([]byte("]"))
-	if err != nil {
-		return err
-	}
-
-	// etl9g.json用io.Writerを閉じる
-	wj.Close()
-
-	// leveldbで利用したファイルを削除
-	ldb.Close()
-	return os.RemoveAll(ldbPath)
+	return MakeDatasets(ETLFormat9g, inputDir, outputDir, outputImageWidth, outputImageHeight, workerNum)
 }
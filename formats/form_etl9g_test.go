@@ -1 +1,24 @@
 package formats
+
+import "testing"
+
+func TestNewRecordETL9GCharacter(t *testing.T) {
+	cases := []struct {
+		code uint16
+		want string
+	}{
+		{0x3021, "亜"},
+		// JIS X 0208にない文字コードは空文字
+		{0x2f21, ""},
+	}
+	for _, c := range cases {
+		record := NewRecordETL9G(0, c.code, "", 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, nil, "")
+		if record.Character != c.want {
+			t.Errorf("ETL9G %#x: Character = %q, want %q", c.code, record.Character, c.want)
+		}
+		record8g := NewRecordETL8G(0, c.code, "", 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, nil, "")
+		if record8g.Character != c.want {
+			t.Errorf("ETL8G %#x: Character = %q, want %q", c.code, record8g.Character, c.want)
+		}
+	}
+}
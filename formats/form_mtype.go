@@ -0,0 +1,192 @@
+package formats
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+const (
+	// mTypeRecordSize M-typeのレコードサイズ
+	mTypeRecordSize = 2052
+
+	// mTypeSampleWidth M-typeのサンプリング画像の幅
+	mTypeSampleWidth = 64
+
+	// mTypeSampleHeight M-typeのサンプリング画像の高さ
+	mTypeSampleHeight = 63
+
+	// mTypeSampleBitDepth M-typeの1ピクセルあたりのbit数
+	mTypeSampleBitDepth = 4
+
+	// mTypeSamplePixelNum M-typeのサンプル画像ピクセル数
+	mTypeSamplePixelNum = mTypeSampleWidth * mTypeSampleHeight
+
+	// mTypeSampleSize M-typeのサンプル画像サイズ
+	mTypeSampleSize = mTypeSamplePixelNum / 2
+)
+
+// RecordMType M-type形式(ETL1, ETL6, ETL7)共通のレコード
+type RecordMType struct {
+	Format      ETLFormat   `json:"format"`
+	Character   string      `json:"character"`
+	Image       image.Image `json:"-"`
+	ImageHash   string      `json:"-"`
+	ImageName   string      `json:"image_name"`
+	ImageWidth  int         `json:"image_width"`
+	ImageHeight int         `json:"image_height"`
+
+	DataNumber                                  uint16 `json:"data_number"`
+	CharacterCode                               string `json:"character_code"`
+	SerialSheetNumber                           uint16 `json:"serial_sheet_number"`
+	JisCharacterCode                            uint8  `json:"jis_character_code"`
+	EbcdicCharacterCode                         uint8  `json:"ebcdic_character_code"`
+	QualityEvaluationOfIndividualCharacterImage uint8  `json:"quality_evaluation_of_individual_character_image"`
+	QualityEvaluationOfCharacterGroup           uint8  `json:"quality_evaluation_of_character_group"`
+	GenderOfWriter                              uint8  `json:"gender_of_writer"`
+	AgeOfWriter                                 uint8  `json:"age_of_writer"`
+	SerialDataNumber                            uint32 `json:"serial_data_number"`
+	IndustryClassificationCode                  uint16 `json:"industry_classification_code"`
+	OccupationClassificationCode                uint16 `json:"occupation_classification_code"`
+	DateOfCollection                            uint16 `json:"date_of_collection"`
+	DateOfScan                                  uint16 `json:"date_of_scan"`
+	YCoordinateOfSampleOnSheet                  uint8  `json:"y_coordinate_of_sample_on_sheet"`
+	XCoordinateOfSampleOnSheet                  uint8  `json:"x_coordinate_of_sample_on_sheet"`
+	MinimumScannedLevel                         uint8  `json:"minimum_scanned_level"`
+	MaximumScannedLevel                         uint8  `json:"maximum_scanned_level"`
+}
+
+// DeallocImage RecordMType.Imageにnilを代入する
+func (r *RecordMType) DeallocImage() {
+	r.Image = nil
+}
+
+// GetKey フォーマット内でユニークなキー
+func (r *RecordMType) GetKey() string {
+	return r.ImageName
+}
+
+// SetKey レコードのキー(画像ファイル名)を変更する
+func (r *RecordMType) SetKey(key string) {
+	r.ImageName = key
+}
+
+// GetImage レコードに格納された画像. DeallocImage後はnil
+func (r *RecordMType) GetImage() image.Image {
+	return r.Image
+}
+
+// GetBitDepth 画像の元の1ピクセルあたりのbit数
+func (r *RecordMType) GetBitDepth() int {
+	return mTypeSampleBitDepth
+}
+
+// GetCharacter レコードの文字
+func (r *RecordMType) GetCharacter() string {
+	return r.Character
+}
+
+// GetCharacterCode レコードの文字コード(JIS X 0201)
+func (r *RecordMType) GetCharacterCode() uint16 {
+	return uint16(r.JisCharacterCode)
+}
+
+// parseMTypeRecord M-type形式のレコードをパーズする
+// - fp: レコードを読み込むio.Reader
+// - format: レコードのフォーマット
+func parseMTypeRecord(fp io.Reader, format ETLFormat) (RecordMType, error) {
+	var err error
+	record := RecordMType{
+		Format:      format,
+		ImageWidth:  mTypeSampleWidth,
+		ImageHeight: mTypeSampleHeight,
+	}
+
+	err = binary.Read(fp, binary.BigEndian, &record.DataNumber)
+	if err != nil {
+		return record, err
+	}
+
+	characterCode := make([]byte, 2)
+	err = binary.Read(fp, binary.BigEndian, &characterCode)
+	if err != nil {
+		return record, err
+	}
+	record.CharacterCode = strings.TrimSpace(string(characterCode))
+
+	fields := []interface{}{
+		&record.SerialSheetNumber,
+		&record.JisCharacterCode,
+		&record.EbcdicCharacterCode,
+		&record.QualityEvaluationOfIndividualCharacterImage,
+		&record.QualityEvaluationOfCharacterGroup,
+		&record.GenderOfWriter,
+		&record.AgeOfWriter,
+		&record.SerialDataNumber,
+		&record.IndustryClassificationCode,
+		&record.OccupationClassificationCode,
+		&record.DateOfCollection,
+		&record.DateOfScan,
+		&record.YCoordinateOfSampleOnSheet,
+		&record.XCoordinateOfSampleOnSheet,
+		&record.MinimumScannedLevel,
+		&record.MaximumScannedLevel,
+	}
+	for _, field := range fields {
+		err = binary.Read(fp, binary.BigEndian, field)
+		if err != nil {
+			return record, err
+		}
+	}
+
+	// undefined
+	undefined1 := make([]byte, 4)
+	err = binary.Read(fp, binary.BigEndian, &undefined1)
+	if err != nil {
+		return record, err
+	}
+
+	sampleImage := image.NewGray(image.Rect(0, 0, mTypeSampleWidth, mTypeSampleHeight))
+	pxIndex := 0
+	for i := 0; i < mTypeSampleSize; i++ {
+		var pxT uint8
+		err = binary.Read(fp, binary.BigEndian, &pxT)
+		if err != nil {
+			return record, err
+		}
+
+		// 8bitから4bit取得
+		px1 := pxT >> 4
+		px2 := pxT & 0x0F
+
+		// 4bitグレイスケールを8bitへ
+		px1 = px1 * (256 / 16)
+		px2 = px2 * (256 / 16)
+
+		sampleImage.Pix[pxIndex] = px1
+		pxIndex++
+		sampleImage.Pix[pxIndex] = px2
+		pxIndex++
+	}
+
+	// uncertain
+	uncertain1 := make([]byte, 4)
+	err = binary.Read(fp, binary.BigEndian, &uncertain1)
+	if err != nil {
+		return record, err
+	}
+
+	imgHash := sha256.New()
+	imgHash.Write(sampleImage.Pix)
+
+	record.Character = jis0201Character(record.JisCharacterCode, format)
+	record.Image = sampleImage
+	record.ImageHash = hex.EncodeToString(imgHash.Sum(nil))
+	record.ImageName = fmt.Sprintf("ETL%s_0x%x_%s.png", strings.ToUpper(string(format)), record.JisCharacterCode, record.ImageHash)
+
+	return record, nil
+}
@@ -0,0 +1,128 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseMTypeRecord(t *testing.T) {
+	b := &bytes.Buffer{}
+	fields := []interface{}{
+		uint16(0x0102),     // Data Number
+		[2]byte{'A', ' '},  // Character Code
+		uint16(0x0304),     // Serial Sheet Number
+		uint8(0x41),        // JIS Code
+		uint8(0xc1),        // EBCDIC Code
+		uint8(5),           // Quality Evaluation of Individual Character Image
+		uint8(6),           // Quality Evaluation of Character Group
+		uint8(1),           // Gender of Writer
+		uint8(30),          // Age of Writer
+		uint32(0x05060708), // Serial Data Number
+		uint16(0x090a),     // Industry Classification Code
+		uint16(0x0b0c),     // Occupation Classification Code
+		uint16(0x0d0e),     // Date of Collection
+		uint16(0x0f10),     // Date of Scan
+		uint8(11),          // Y Coordinate of Sample on Sheet
+		uint8(12),          // X Coordinate of Sample on Sheet
+		uint8(13),          // Minimum Scanned Level
+		uint8(14),          // Maximum Scanned Level
+		[4]byte{},          // undefined
+	}
+	for _, field := range fields {
+		binary.Write(b, binary.BigEndian, field)
+	}
+	if b.Len() != 32 {
+		t.Fatalf("header size = %d, want 32", b.Len())
+	}
+
+	// 1バイトに2画素. 上位4bitが先の画素
+	for i := 0; i < mTypeSampleSize; i++ {
+		b.WriteByte(byte(2*i%16)<<4 | byte((2*i+1)%16))
+	}
+	b.Write([]byte{0xff, 0xff, 0xff, 0xff}) // uncertain
+	if b.Len() != mTypeRecordSize {
+		t.Fatalf("record size = %d, want %d", b.Len(), mTypeRecordSize)
+	}
+
+	r := bytes.NewReader(b.Bytes())
+	record, err := parseMTypeRecord(r, ETLFormat7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d bytes remain after the record", r.Len())
+	}
+
+	if record.DataNumber != 0x0102 {
+		t.Errorf("DataNumber = %#x, want 0x0102", record.DataNumber)
+	}
+	if record.CharacterCode != "A" {
+		t.Errorf("CharacterCode = %q, want %q", record.CharacterCode, "A")
+	}
+	if record.SerialSheetNumber != 0x0304 {
+		t.Errorf("SerialSheetNumber = %#x, want 0x0304", record.SerialSheetNumber)
+	}
+	if record.JisCharacterCode != 0x41 {
+		t.Errorf("JisCharacterCode = %#x, want 0x41", record.JisCharacterCode)
+	}
+	if record.EbcdicCharacterCode != 0xc1 {
+		t.Errorf("EbcdicCharacterCode = %#x, want 0xc1", record.EbcdicCharacterCode)
+	}
+	if record.SerialDataNumber != 0x05060708 {
+		t.Errorf("SerialDataNumber = %#x, want 0x05060708", record.SerialDataNumber)
+	}
+	if record.DateOfScan != 0x0f10 {
+		t.Errorf("DateOfScan = %#x, want 0x0f10", record.DateOfScan)
+	}
+	if record.MaximumScannedLevel != 14 {
+		t.Errorf("MaximumScannedLevel = %d, want 14", record.MaximumScannedLevel)
+	}
+	if record.Character != "A" {
+		t.Errorf("Character = %q, want %q", record.Character, "A")
+	}
+
+	// 4bitの画素は8bitになるよう左シフトする
+	checkGrayPixels(t, record.Image, mTypeSampleWidth, mTypeSampleHeight, func(i int) uint8 {
+		return uint8(i%16) << 4
+	})
+}
+
+func TestParseMTypeRecordUnmappedCode(t *testing.T) {
+	b := make([]byte, mTypeRecordSize)
+	b[6] = 0x80 // JIS Code. JIS X 0201にない文字コード
+
+	record, err := parseMTypeRecord(bytes.NewReader(b), ETLFormat7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.JisCharacterCode != 0x80 {
+		t.Errorf("JisCharacterCode = %#x, want 0x80", record.JisCharacterCode)
+	}
+	if record.Character != "" {
+		t.Errorf("Character = %q, want empty", record.Character)
+	}
+}
+
+func TestParseMTypeRecordHiragana(t *testing.T) {
+	b := make([]byte, mTypeRecordSize)
+	b[6] = 0xdd // JIS Code. ETL7のんはカタカナのンの文字コード
+
+	// ETL7はひらがな, ETL6はカタカナとして変換する
+	cases := []struct {
+		format ETLFormat
+		want   string
+	}{
+		{ETLFormat7, "ん"},
+		{ETLFormat6, "ﾝ"},
+	}
+	for _, c := range cases {
+		record, err := parseMTypeRecord(bytes.NewReader(b), c.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Character != c.want {
+			t.Errorf("ETL%s: Character = %q, want %q", c.format, record.Character, c.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// RecordIterator ETLファイルからレコードを1件ずつパーズして返す
+//
+//	it, err := formats.OpenETLFile("ETL9G/ETL9G_01", formats.ETLFormat9g)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		record := it.Record()
+//		...
+//	}
+//	return it.Err()
+//
+// レコードのパーズに失敗した場合やファイル末尾のレコードが欠けている場合, Errは*RecordErrorを返す.
+// パーズに失敗した場合は再度Nextを呼び出すと次のレコードから読み込みを再開する
+type RecordIterator struct {
+	spec   *FormatSpec
+	path   string
+	r      io.Reader
+	closer io.Closer
+	buf    []byte
+	index  int
+	record Record
+	err    error
+	done   bool
+}
+
+// OpenETLFile ETLファイルを開きRecordIteratorを生成する
+// FormatSpec.Prepareが存在する場合はファイルのあるディレクトリを引数に呼び出し, 返した関数でパーズする
+// - fpath: ETLファイルのパス
+// - format: ETLファイルのフォーマット
+func OpenETLFile(fpath string, format ETLFormat) (*RecordIterator, error) {
+	spec, err := LookupFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err = prepareFormat(spec, filepath.Dir(fpath))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	it := newRecordIterator(f, spec)
+	it.path = fpath
+	it.closer = f
+	return it, nil
+}
+
+// NewRecordIterator io.ReaderからRecordIteratorを生成する
+// FormatSpec.Prepareは呼び出さずFormatSpec.Parseでパーズする. ETL2のeuc_co59.datなど入力ディレクトリのファイルは使わない
+// - r: ETLファイルの内容を読み込むio.Reader
+// - format: ETLファイルのフォーマット
+func NewRecordIterator(r io.Reader, format ETLFormat) (*RecordIterator, error) {
+	spec, err := LookupFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRecordIterator(r, spec), nil
+}
+
+// newRecordIterator specのParseでパーズするRecordIteratorを生成する
+func newRecordIterator(r io.Reader, spec *FormatSpec) *RecordIterator {
+	return &RecordIterator{
+		spec:  spec,
+		r:     bufio.NewReaderSize(r, spec.RecordSize*16),
+		buf:   make([]byte, spec.RecordSize),
+		index: -1,
+	}
+}
+
+// prepareFormat FormatSpec.Prepareが存在する場合は, 返した関数をParseにしたFormatSpecのコピーを返す
+// - spec: フォーマットの情報
+// - inputDir: 入力ファイルがあるディレクトリパス
+func prepareFormat(spec *FormatSpec, inputDir string) (*FormatSpec, error) {
+	if spec.Prepare == nil {
+		return spec, nil
+	}
+
+	parse, err := spec.Prepare(inputDir)
+	if err != nil {
+		return nil, err
+	}
+	prepared := *spec
+	prepared.Parse = parse
+	prepared.Prepare = nil
+	return &prepared, nil
+}
+
+// Next 次のレコードを読み込む. 終端に達したかエラーが発生した場合はfalseを返す
+func (it *RecordIterator) Next() bool {
+	it.record = nil
+	it.err = nil
+	if it.done {
+		return false
+	}
+
+	for {
+		// レコードサイズ分メモリへ読み込み, そこから処理を行う
+		_, err := io.ReadFull(it.r, it.buf)
+		if err != nil {
+			it.done = true
+			switch err {
+			case io.EOF:
+			case io.ErrUnexpectedEOF:
+				it.err = &RecordError{Path: it.path, Index: it.index + 1, Err: err}
+			default:
+				it.err = err
+			}
+			return false
+		}
+		it.index++
+
+		// ダミーレコードは読み飛ばす
+		if it.index < it.spec.DummyRecordNum {
+			continue
+		}
+
+		record, err := it.spec.Parse(bytes.NewReader(it.buf))
+		if err != nil {
+			it.err = &RecordError{Path: it.path, Index: it.index, Err: err}
+			return false
+		}
+		it.record = record
+		return true
+	}
+}
+
+// Record Nextで読み込んだレコードを返す
+func (it *RecordIterator) Record() Record {
+	return it.record
+}
+
+// Index Nextで読み込んだレコードのファイル内でのインデックスを返す. ダミーレコードも数える
+func (it *RecordIterator) Index() int {
+	return it.index
+}
+
+// Err 読み込み中に発生したエラーを返す. 終端に達しただけの場合はnil
+func (it *RecordIterator) Err() error {
+	return it.err
+}
+
+// Close OpenETLFileで開いたファイルを閉じる
+func (it *RecordIterator) Close() error {
+	if it.closer == nil {
+		return nil
+	}
+	return it.closer.Close()
+}
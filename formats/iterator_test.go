@@ -0,0 +1,206 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// testIteratorFormat テスト用のフォーマット. JIS Codeが0xffのレコードはパーズに失敗する
+const testIteratorFormat ETLFormat = "test-iterator"
+
+func init() {
+	RegisterFormat(&FormatSpec{
+		Format:         testIteratorFormat,
+		Name:           "TEST-ITERATOR",
+		RecordSize:     mTypeRecordSize,
+		RecordTotalNum: 3,
+		FileNames:      []string{"TEST_ITERATOR"},
+		Parse: func(fp io.Reader) (Record, error) {
+			b := make([]byte, mTypeRecordSize)
+			_, err := io.ReadFull(fp, b)
+			if err != nil {
+				return nil, err
+			}
+			if b[6] == 0xff {
+				return nil, errors.New("broken record")
+			}
+			return ParseETL7Record(bytes.NewReader(b))
+		},
+	})
+}
+
+// binaryTestRecords ETL8B形式のテスト用のレコードを連結する. 先頭にダミーレコードを含む
+// - codes: ダミーレコードに続くレコードのJIS Code
+func binaryTestRecords(codes ...uint16) []byte {
+	b := make([]byte, etl8bRecordSize*(len(codes)+1))
+	for i, code := range codes {
+		binary.BigEndian.PutUint16(b[etl8bRecordSize*(i+1)+2:], code)
+	}
+	return b
+}
+
+func TestRecordIteratorSkipsDummyRecord(t *testing.T) {
+	it, err := NewRecordIterator(bytes.NewReader(binaryTestRecords(0x3021, 0x3022)), ETLFormat8b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ダミーレコードは返さないが, Indexはダミーレコードも数える
+	want := []struct {
+		index     int
+		character string
+	}{
+		{1, "亜"},
+		{2, "唖"},
+	}
+	for _, w := range want {
+		if !it.Next() {
+			t.Fatalf("Next = false, want record %d: %v", w.index, it.Err())
+		}
+		if it.Index() != w.index {
+			t.Errorf("Index = %d, want %d", it.Index(), w.index)
+		}
+		if got := it.Record().GetCharacter(); got != w.character {
+			t.Errorf("record %d: Character = %q, want %q", w.index, got, w.character)
+		}
+	}
+	if it.Next() {
+		t.Fatalf("Next = true after the last record")
+	}
+	if it.Err() != nil {
+		t.Errorf("Err = %v, want nil", it.Err())
+	}
+	if it.Record() != nil {
+		t.Errorf("Record = %v after the last record, want nil", it.Record())
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("Close = %v, want nil", err)
+	}
+}
+
+func TestRecordIteratorTruncatedRecord(t *testing.T) {
+	b := binaryTestRecords(0x3021)
+	b = append(b, make([]byte, etl8bRecordSize/2)...)
+	it, err := NewRecordIterator(bytes.NewReader(b), ETLFormat8b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !it.Next() {
+		t.Fatalf("Next = false, want record 1: %v", it.Err())
+	}
+	if it.Next() {
+		t.Fatalf("Next = true for the truncated record")
+	}
+	rerr, ok := it.Err().(*RecordError)
+	if !ok {
+		t.Fatalf("Err = %v, want *RecordError", it.Err())
+	}
+	if rerr.Index != 2 || rerr.Err != io.ErrUnexpectedEOF {
+		t.Errorf("Err = %v, want record 2: %v", rerr, io.ErrUnexpectedEOF)
+	}
+
+	// 終端に達したため以降のNextはエラーなしでfalseを返す
+	if it.Next() {
+		t.Fatalf("Next = true after the truncated record")
+	}
+	if it.Err() != nil {
+		t.Errorf("Err = %v after the truncated record, want nil", it.Err())
+	}
+}
+
+func TestRecordIteratorResumesAfterParseError(t *testing.T) {
+	b := []byte{}
+	for _, code := range []uint8{'A', 0xff, 'B'} {
+		b = append(b, mTypeTestRecord(code, 0)...)
+	}
+	it, err := NewRecordIterator(bytes.NewReader(b), testIteratorFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !it.Next() || it.Record().GetCharacter() != "A" {
+		t.Fatalf("record 0: Next = false or wrong record: %v", it.Err())
+	}
+
+	if it.Next() {
+		t.Fatalf("Next = true for the broken record")
+	}
+	rerr, ok := it.Err().(*RecordError)
+	if !ok || rerr.Index != 1 {
+		t.Fatalf("Err = %v, want *RecordError for record 1", it.Err())
+	}
+	if it.Index() != 1 {
+		t.Errorf("Index = %d, want 1", it.Index())
+	}
+
+	// 再度Nextを呼び出すと次のレコードから再開する
+	if !it.Next() {
+		t.Fatalf("Next = false after the broken record: %v", it.Err())
+	}
+	if it.Index() != 2 || it.Record().GetCharacter() != "B" {
+		t.Errorf("record %d: Character = %q, want record 2: %q", it.Index(), it.Record().GetCharacter(), "B")
+	}
+	if it.Err() != nil {
+		t.Errorf("Err = %v, want nil", it.Err())
+	}
+
+	if it.Next() || it.Err() != nil {
+		t.Errorf("Next at the end: Err = %v, want nil", it.Err())
+	}
+}
+
+func TestOpenETLFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etlcdb-iterator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fpath := path.Join(dir, "ETL8B2C1")
+	b := binaryTestRecords(0x3021)
+	b = append(b, 0)
+	err = ioutil.WriteFile(fpath, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := OpenETLFile(fpath, ETLFormat8b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() {
+		t.Fatalf("Next = false, want record 1: %v", it.Err())
+	}
+	if it.Next() {
+		t.Fatalf("Next = true for the truncated record")
+	}
+	// エラーには開いたファイルのパスを含める
+	rerr, ok := it.Err().(*RecordError)
+	if !ok || rerr.Path != fpath {
+		t.Errorf("Err = %v, want *RecordError for %s", it.Err(), fpath)
+	}
+
+	// Closeでファイルを閉じる. 閉じたファイルを再度閉じるとエラーになる
+	if err := it.Close(); err != nil {
+		t.Errorf("Close = %v, want nil", err)
+	}
+	if err := it.Close(); err == nil {
+		t.Errorf("second Close = nil, want an error")
+	}
+
+	_, err = OpenETLFile(path.Join(dir, "missing"), ETLFormat8b)
+	if !os.IsNotExist(err) {
+		t.Errorf("OpenETLFile(missing) = %v, want a not-exist error", err)
+	}
+	_, err = OpenETLFile(fpath, "unknown")
+	if err == nil {
+		t.Errorf("OpenETLFile(unknown format) = nil, want an error")
+	}
+}
@@ -0,0 +1,110 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ImageLayout データセットの画像の配置
+type ImageLayout string
+
+const (
+	// ImageLayoutFlat すべての画像を出力ディレクトリへ直接出力する
+	ImageLayoutFlat ImageLayout = "flat"
+
+	// ImageLayoutCode 文字コードの16進数表記 e.g) 0x2422 のディレクトリごとに画像を出力する
+	ImageLayoutCode ImageLayout = "code"
+
+	// ImageLayoutCharacter 文字 e.g) あ のディレクトリごとに画像を出力する
+	// ディレクトリ名に使えない文字の場合は U+3042 のようなUnicodeの表記にする
+	// 対応表にない文字コードで文字が空の場合は ImageLayoutCode と同じ 0x2422 のような表記にする
+	ImageLayoutCharacter ImageLayout = "character"
+)
+
+// classIndexName ImageLayoutCodeなどで出力するディレクトリと文字の対応を記録するファイル名
+const classIndexName = "classes.json"
+
+// ClassIndexEntry クラスのディレクトリと文字の対応
+type ClassIndexEntry struct {
+	Directory string `json:"directory"`
+	Character string `json:"character"`
+}
+
+// checkImageLayout 対応している画像の配置かどうか確認する
+func checkImageLayout(layout ImageLayout) error {
+	switch layout {
+	case ImageLayoutFlat, ImageLayoutCode, ImageLayoutCharacter:
+		return nil
+	}
+	return fmt.Errorf("unknown image layout: %s", layout)
+}
+
+// classDir レコードの画像を出力するディレクトリ名. ImageLayoutFlatの場合は空文字
+// ImageLayoutCharacterで文字が空(対応表にない文字コード)の場合は, 文字コードごとに分かれるようImageLayoutCodeと同じ名前にする
+// - record: レコード
+// - layout: 画像の配置
+func classDir(record Record, layout ImageLayout) string {
+	switch layout {
+	case ImageLayoutCode:
+		return codeDir(record.GetCharacterCode())
+	case ImageLayoutCharacter:
+		if record.GetCharacter() == "" {
+			return codeDir(record.GetCharacterCode())
+		}
+		return characterDir(record.GetCharacter())
+	}
+	return ""
+}
+
+// codeDir 文字コードを16進数表記のディレクトリ名にする
+func codeDir(code uint16) string {
+	return fmt.Sprintf("0x%04x", code)
+}
+
+// characterDir 文字をディレクトリ名にする
+// 制御文字, 空白, パスの区切りなどファイルシステムで扱いにくい文字を含む場合はUnicodeの表記にする
+func characterDir(character string) string {
+	safe := character != "" && character != "." && character != ".."
+	for _, r := range character {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return character
+	}
+
+	codes := []string{}
+	for _, r := range character {
+		codes = append(codes, fmt.Sprintf("U+%04X", r))
+	}
+	if len(codes) == 0 {
+		return "U+0000"
+	}
+	return strings.Join(codes, "_")
+}
+
+// writeClassIndex クラスのディレクトリと文字の対応をディレクトリ名順にJSONで出力する
+// - outputDir: データセットを出力するディレクトリパス
+// - classes: ディレクトリ名と文字の対応
+func writeClassIndex(outputDir string, classes map[string]string) error {
+	entries := make([]ClassIndexEntry, 0, len(classes))
+	for dir, character := range classes {
+		entries = append(entries, ClassIndexEntry{Directory: dir, Character: character})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Directory < entries[j].Directory
+	})
+
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(outputDir, classIndexName), b, 0644)
+}
@@ -0,0 +1,171 @@
+package formats
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestCharacterDir(t *testing.T) {
+	cases := []struct {
+		character string
+		want      string
+	}{
+		{"あ", "あ"},
+		{"A", "A"},
+		{"", "U+0000"},
+		{".", "U+002E"},
+		{"..", "U+002E_U+002E"},
+		{"/", "U+002F"},
+		{" ", "U+0020"},
+		{"\x00", "U+0000"},
+		{"a:", "U+0061_U+003A"},
+	}
+	for _, c := range cases {
+		if got := characterDir(c.character); got != c.want {
+			t.Errorf("characterDir(%q) = %q, want %q", c.character, got, c.want)
+		}
+	}
+}
+
+func TestClassDirUnmappedCharacter(t *testing.T) {
+	// 対応表にない文字コードは文字が空でも文字コードごとに別のディレクトリにする
+	records := []*RecordBinary{
+		{JisCharacterCode: 0x2f21},
+		{JisCharacterCode: 0x7f7e},
+	}
+	want := []string{"0x2f21", "0x7f7e"}
+	for i, record := range records {
+		if got := classDir(record, ImageLayoutCharacter); got != want[i] {
+			t.Errorf("classDir(%#x, %s) = %q, want %q", record.JisCharacterCode, ImageLayoutCharacter, got, want[i])
+		}
+		if got := classDir(record, ImageLayoutCode); got != want[i] {
+			t.Errorf("classDir(%#x, %s) = %q, want %q", record.JisCharacterCode, ImageLayoutCode, got, want[i])
+		}
+	}
+}
+
+func TestImageSinkPath(t *testing.T) {
+	record := &RecordBinary{Character: "亜", ImageName: "ETL8B_0x3021_abc.png", JisCharacterCode: 0x3021}
+	cases := []struct {
+		layout   ImageLayout
+		encoding ImageEncoding
+		want     string
+	}{
+		{ImageLayoutFlat, ImageEncodingPNG, "ETL8B_0x3021_abc.png"},
+		{ImageLayoutCode, ImageEncodingPNG, "0x3021/ETL8B_0x3021_abc.png"},
+		{ImageLayoutCharacter, ImageEncodingPNG, "亜/ETL8B_0x3021_abc.png"},
+		{ImageLayoutCode, ImageEncodingBMP, "0x3021/ETL8B_0x3021_abc.bmp"},
+	}
+	for _, c := range cases {
+		encoder, err := NewImageEncoder(c.encoding, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink, err := NewImageSink("out", c.layout, encoder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sink.(imagePathSink).imagePath(record.GetKey(), record); got != c.want {
+			t.Errorf("%s, %s: imagePath = %q, want %q", c.layout, c.encoding, got, c.want)
+		}
+	}
+}
+
+func TestMakeDatasetsLayout(t *testing.T) {
+	inputDir := writeTestInput(t)
+	defer os.RemoveAll(inputDir)
+	outputDir, err := ioutil.TempDir("", "etlcdb-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	// 同じ出力ディレクトリへ配置を変えて続けて出力する
+	cases := []struct {
+		layout  ImageLayout
+		dirs    []string
+		classes []ClassIndexEntry
+	}{
+		{
+			layout: ImageLayoutCode,
+			dirs:   []string{"0x0041", "0x0042", "0x0043"},
+			classes: []ClassIndexEntry{
+				{Directory: "0x0041", Character: "A"},
+				{Directory: "0x0042", Character: "B"},
+				{Directory: "0x0043", Character: "C"},
+			},
+		},
+		{
+			layout: ImageLayoutCharacter,
+			dirs:   []string{"A", "B", "C"},
+			classes: []ClassIndexEntry{
+				{Directory: "A", Character: "A"},
+				{Directory: "B", Character: "B"},
+				{Directory: "C", Character: "C"},
+			},
+		},
+		{
+			layout: ImageLayoutFlat,
+			dirs:   []string{},
+		},
+	}
+	for _, c := range cases {
+		err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{Layout: c.layout})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 前回の配置のディレクトリは残らない
+		dirs := []string{}
+		images := 0
+		fis, err := ioutil.ReadDir(outputDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range fis {
+			switch {
+			case fi.IsDir():
+				dirs = append(dirs, fi.Name())
+				sub, err := ioutil.ReadDir(path.Join(outputDir, fi.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(sub) != 2 {
+					t.Errorf("%s: %d images in %s, want 2", c.layout, len(sub), fi.Name())
+				}
+				images += len(sub)
+			case path.Ext(fi.Name()) == ".png":
+				images++
+			}
+		}
+		if !reflect.DeepEqual(dirs, c.dirs) {
+			t.Errorf("%s: directories = %v, want %v", c.layout, dirs, c.dirs)
+		}
+		if images != 2*testRecordNum {
+			t.Errorf("%s: %d images, want %d", c.layout, images, 2*testRecordNum)
+		}
+
+		b, err := ioutil.ReadFile(path.Join(outputDir, classIndexName))
+		if c.classes == nil {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: %s remains: %v", c.layout, classIndexName, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		classes := []ClassIndexEntry{}
+		err = json.Unmarshal(b, &classes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(classes, c.classes) {
+			t.Errorf("%s: classes = %+v, want %+v", c.layout, classes, c.classes)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package formats
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// manifestName データセットのディレクトリへ出力するマニフェストのファイル名
+const manifestName = "manifest.json"
+
+// buildManifest データセット作成時の入力ファイルと設定を記録したマニフェスト
+// 再作成時に入力ファイルと設定が変わっていないファイルの出力を再利用するために使う
+type buildManifest struct {
+	Format ETLFormat      `json:"format"`
+	Params manifestParams `json:"params"`
+
+	// Metadata 再利用時にレコードを読み込むメタデータの形式. JSONとJSON Linesのどちらも出力しなかった場合は空
+	Metadata MetadataFormat `json:"metadata,omitempty"`
+
+	Files map[string]*manifestFile `json:"files"`
+}
+
+// manifestParams 出力に影響する設定
+type manifestParams struct {
+	OutputImageWidth  int            `json:"output_image_width"`
+	OutputImageHeight int            `json:"output_image_height"`
+	Layout            ImageLayout    `json:"layout,omitempty"`
+	Encoding          ImageEncoding  `json:"encoding,omitempty"`
+	PNGCompression    PNGCompression `json:"png_compression,omitempty"`
+	PixelScaling      PixelScaling   `json:"pixel_scaling,omitempty"`
+	ResizeFilter      ResizeFilter   `json:"resize_filter,omitempty"`
+	ResizeMode        ResizeMode     `json:"resize_mode,omitempty"`
+	PadColor          string         `json:"pad_color,omitempty"`
+	CropInk           bool           `json:"crop_ink,omitempty"`
+	InkMargin         int            `json:"ink_margin,omitempty"`
+	InkThreshold      int            `json:"ink_threshold,omitempty"`
+	CenterOfMass      bool           `json:"center_of_mass,omitempty"`
+}
+
+// manifestFile 入力ファイルごとの情報
+type manifestFile struct {
+	// SHA256 入力ファイルのハッシュ値
+	SHA256 string `json:"sha256"`
+
+	// Size 入力ファイルのサイズ
+	Size int64 `json:"size"`
+
+	// Images 入力ファイルから出力した画像の出力ディレクトリからの相対パス
+	// ImageLayoutFlatの場合はファイル名(拡張子を画像の形式に合わせたRecord.GetKey)
+	Images []string `json:"images"`
+
+	// Classes ImageLayoutFlat以外の場合に出力したディレクトリと文字の対応
+	Classes map[string]string `json:"classes,omitempty"`
+}
+
+// newManifestParams オプションからマニフェストに記録する設定を生成する
+func newManifestParams(opts *DatasetsOptions) manifestParams {
+	params := manifestParams{
+		OutputImageWidth:  opts.OutputImageWidth,
+		OutputImageHeight: opts.OutputImageHeight,
+		Layout:            opts.Layout,
+		Encoding:          opts.Encoding,
+		PNGCompression:    opts.PNGCompression,
+		PixelScaling:      opts.PixelScaling,
+		ResizeFilter:      opts.Resize.Filter,
+		ResizeMode:        opts.Resize.Mode,
+	}
+
+	// デフォルトの設定はそれらを記録していなかったマニフェストと同じ扱いにする
+	if params.Layout == ImageLayoutFlat {
+		params.Layout = ""
+	}
+	if params.PixelScaling == PixelScalingShift {
+		params.PixelScaling = ""
+	}
+	if params.ResizeFilter == ResizeFilterLanczos {
+		params.ResizeFilter = ""
+	}
+	if params.ResizeMode == ResizeModeStretch {
+		params.ResizeMode = ""
+	}
+	if params.ResizeMode == ResizeModeFit {
+		params.PadColor = padColorString(opts.Resize.PadColor)
+	}
+	if opts.Normalize.CropInk || opts.Normalize.CenterOfMass {
+		params.CropInk = opts.Normalize.CropInk
+		params.CenterOfMass = opts.Normalize.CenterOfMass
+		params.InkThreshold = opts.Normalize.InkThreshold
+		if params.InkThreshold <= 0 {
+			params.InkThreshold = defaultInkThreshold
+		}
+		if params.CropInk {
+			params.InkMargin = opts.Normalize.InkMargin
+		}
+	}
+	if params.Encoding == ImageEncodingPNG {
+		params.Encoding = ""
+	}
+	if params.Encoding != "" || params.PNGCompression == PNGCompressionBest {
+		params.PNGCompression = ""
+	}
+	return params
+}
+
+// readManifest 前回出力したマニフェストを読み込む. 存在しない場合はnilを返す
+// - outputDir: データセットを出力したディレクトリパス
+func readManifest(outputDir string) (*buildManifest, error) {
+	b, err := ioutil.ReadFile(path.Join(outputDir, manifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	m := &buildManifest{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// write マニフェストを出力する
+// - outputDir: データセットを出力するディレクトリパス
+func (m *buildManifest) write(outputDir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(outputDir, manifestName), b, 0644)
+}
+
+// fileSHA256 ファイルのハッシュ値とサイズを求める
+func fileSHA256(fpath string) (string, int64, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// loadCheckpoint leveldbに記録された入力ファイルの処理結果を取得する
+// 記録がない場合や読み込めない場合はnilを返す
+// - ldb: leveldb
+// - fileName: 入力ファイル名
+func loadCheckpoint(ldb *leveldb.DB, fileName string) (*manifestFile, error) {
+	b, err := ldb.Get(checkpointKey(fileName), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	mf := &manifestFile{}
+	if json.Unmarshal(b, mf) != nil {
+		return nil, nil
+	}
+	return mf, nil
+}
+
+// reusePreviousOutputs 前回から入力ファイルと設定が変わっていないファイルのメタデータをleveldbへ書き込み,
+// チェックポイントを記録して処理済みにする
+// 前回のメタデータや画像が欠けている場合はそのファイルを再利用しない
+// - ldb: leveldb
+// - spec: フォーマットの情報
+// - inputDir: 入力ファイルがあるディレクトリパス
+// - outputDir: データセットを出力するディレクトリパス
+// - prev: 前回のマニフェスト
+func reusePreviousOutputs(ldb *leveldb.DB, spec *FormatSpec, inputDir, outputDir string, prev *buildManifest) error {
+	// 画像ファイル名(メタデータのimage_name)から入力ファイル名を引く
+	imageFiles := map[string]string{}
+	reusable := map[string]*manifestFile{}
+	for _, fileName := range spec.FileNames {
+		mf, ok := prev.Files[fileName]
+		if !ok {
+			continue
+		}
+
+		done, err := loadCheckpoint(ldb, fileName)
+		if err != nil {
+			return err
+		}
+		if done != nil {
+			// Resumeで処理済み
+			continue
+		}
+
+		sum, size, err := fileSHA256(path.Join(inputDir, fileName))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if sum != mf.SHA256 || size != mf.Size || !imagesExist(outputDir, mf.Images) {
+			continue
+		}
+
+		reusable[fileName] = mf
+		for _, image := range mf.Images {
+			imageFiles[path.Base(image)] = fileName
+		}
+	}
+	if len(reusable) == 0 {
+		return nil
+	}
+
+	// 前回出力したメタデータから再利用するレコードを取り出す
+	metadataFormat := prev.Metadata
+	if metadataFormat != MetadataFormatJSON && metadataFormat != MetadataFormatJSONL {
+		return nil
+	}
+	f, err := os.Open(path.Join(outputDir, metadataFileName(spec.Name, metadataFormat)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	batches := map[string]*leveldb.Batch{}
+	found := map[string]int{}
+	dec := json.NewDecoder(f)
+	if metadataFormat == MetadataFormatJSON {
+		// 先頭の`[`を読み飛ばす
+		_, err = dec.Token()
+		if err != nil {
+			// 前回のメタデータが壊れている場合は再利用しない
+			return nil
+		}
+	}
+	for dec.More() {
+		var rjb json.RawMessage
+		err = dec.Decode(&rjb)
+		if err != nil {
+			return nil
+		}
+
+		var key struct {
+			ImageName string `json:"image_name"`
+		}
+		err = json.Unmarshal(rjb, &key)
+		if err != nil {
+			return nil
+		}
+
+		fileName, ok := imageFiles[key.ImageName]
+		if !ok {
+			continue
+		}
+		if batches[fileName] == nil {
+			batches[fileName] = new(leveldb.Batch)
+		}
+		batches[fileName].Put([]byte(key.ImageName), rjb)
+		found[fileName]++
+	}
+
+	for _, fileName := range spec.FileNames {
+		mf, ok := reusable[fileName]
+		if !ok {
+			continue
+		}
+
+		// メタデータが揃っていないファイルは作り直す. 同じキーのレコードはメタデータでは1件のため, 重複を除いて比べる
+		if found[fileName] != uniqueImageNum(mf.Images) {
+			continue
+		}
+
+		batch := batches[fileName]
+		if batch == nil {
+			batch = new(leveldb.Batch)
+		}
+		cb, err := json.Marshal(mf)
+		if err != nil {
+			return err
+		}
+		batch.Put(checkpointKey(fileName), cb)
+
+		err = ldb.Write(batch, nil)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: %s unchanged, reused\n", spec.Name, fileName)
+	}
+	return nil
+}
+
+// imagesExist 画像がすべて存在するかどうか
+func imagesExist(outputDir string, images []string) bool {
+	for _, image := range images {
+		if _, err := os.Stat(path.Join(outputDir, image)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// removeStaleImages 前回出力したが今回出力しなかった画像を削除する
+// - outputDir: データセットを出力したディレクトリパス
+// - prev: 前回のマニフェスト
+// - cur: 今回のマニフェスト
+func removeStaleImages(outputDir string, prev, cur *buildManifest) error {
+	images := map[string]bool{}
+	for _, mf := range cur.Files {
+		for _, image := range mf.Images {
+			images[image] = true
+		}
+	}
+
+	dirs := map[string]bool{}
+	for _, mf := range prev.Files {
+		for _, image := range mf.Images {
+			if images[image] {
+				continue
+			}
+			err := os.Remove(path.Join(outputDir, image))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if dir := path.Dir(image); dir != "." {
+				dirs[dir] = true
+			}
+		}
+	}
+
+	// 空になったクラスのディレクトリを削除する. 空でない場合は失敗するため無視する
+	for dir := range dirs {
+		os.Remove(path.Join(outputDir, dir))
+	}
+	return nil
+}
+
+// uniqueImageNum 重複を除いた画像ファイル名の数
+func uniqueImageNum(images []string) int {
+	names := map[string]bool{}
+	for _, image := range images {
+		names[path.Base(image)] = true
+	}
+	return len(names)
+}
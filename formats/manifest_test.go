@@ -0,0 +1,186 @@
+package formats
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMakeDatasetsReuse(t *testing.T) {
+	// 再利用した場合は前回の画像をそのまま残すため, 画像を書き換えて再利用したかどうかを確かめる
+	marker := []byte("reused")
+
+	cases := []struct {
+		name string
+		opts DatasetsOptions
+		// modify 前回のビルドの後に入力ファイルや出力を変更する
+		modify func(t *testing.T, inputDir, outputDir string, prev *buildManifest)
+		reuse1 bool
+		reuse2 bool
+	}{
+		{
+			name:   "unchanged",
+			reuse1: true,
+			reuse2: true,
+		},
+		{
+			name: "input changed",
+			modify: func(t *testing.T, inputDir, outputDir string, prev *buildManifest) {
+				writeTestFile(t, inputDir, "TEST_1", 2*testRecordNum)
+			},
+			reuse1: false,
+			reuse2: true,
+		},
+		{
+			name:   "params changed",
+			opts:   DatasetsOptions{OutputImageWidth: 32, OutputImageHeight: 32},
+			reuse1: false,
+			reuse2: false,
+		},
+		{
+			name: "image missing",
+			modify: func(t *testing.T, inputDir, outputDir string, prev *buildManifest) {
+				err := os.Remove(path.Join(outputDir, prev.Files["TEST_1"].Images[1]))
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			reuse1: false,
+			reuse2: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			inputDir := writeTestInput(t)
+			defer os.RemoveAll(inputDir)
+			outputDir, err := ioutil.TempDir("", "etlcdb-output")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(outputDir)
+
+			err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			prev, err := readManifest(outputDir)
+			if err != nil || prev == nil {
+				t.Fatalf("readManifest = %v, %v", prev, err)
+			}
+			markers := map[string]string{}
+			for _, fileName := range []string{"TEST_1", "TEST_2"} {
+				mf := prev.Files[fileName]
+				if mf == nil || len(mf.Images) != testRecordNum {
+					t.Fatalf("manifest of %s = %+v", fileName, mf)
+				}
+				markers[fileName] = path.Join(outputDir, mf.Images[0])
+				err = ioutil.WriteFile(markers[fileName], marker, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.modify != nil {
+				c.modify(t, inputDir, outputDir, prev)
+			}
+
+			err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for fileName, want := range map[string]bool{"TEST_1": c.reuse1, "TEST_2": c.reuse2} {
+				b, _ := ioutil.ReadFile(markers[fileName])
+				if reused := string(b) == string(marker); reused != want {
+					t.Errorf("%s reused = %v, want %v", fileName, reused, want)
+				}
+			}
+
+			// 再利用したかどうかに関わらず, 今回の出力だけが揃っている
+			cur, err := readManifest(outputDir)
+			if err != nil || cur == nil {
+				t.Fatalf("readManifest = %v, %v", cur, err)
+			}
+			images := map[string]bool{}
+			for fileName, mf := range cur.Files {
+				if len(mf.Images) != testRecordNum {
+					t.Errorf("%d images of %s in manifest, want %d", len(mf.Images), fileName, testRecordNum)
+				}
+				if !imagesExist(outputDir, mf.Images) {
+					t.Errorf("images of %s are missing", fileName)
+				}
+				for _, image := range mf.Images {
+					images[image] = true
+				}
+			}
+			for _, mf := range prev.Files {
+				for _, image := range mf.Images {
+					_, err := os.Stat(path.Join(outputDir, image))
+					if exists := err == nil; exists != images[image] {
+						t.Errorf("%s exists = %v, want %v", image, exists, images[image])
+					}
+				}
+			}
+			records := readJSONMetadata(t, path.Join(outputDir, "test.json"))
+			if len(records) != 2*testRecordNum {
+				t.Errorf("%d records in metadata, want %d", len(records), 2*testRecordNum)
+			}
+		})
+	}
+}
+
+func TestMakeDatasetsReuseDuplicateKeys(t *testing.T) {
+	inputDir := writeTestInput(t)
+	defer os.RemoveAll(inputDir)
+	outputDir, err := ioutil.TempDir("", "etlcdb-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	// TEST_1の1件目と2件目は同じ文字と画像のため, 同じキー(画像ファイル名)になる
+	b := append(mTypeTestRecord('A', 0), mTypeTestRecord('A', 0)...)
+	b = append(b, mTypeTestRecord('C', 2)...)
+	err = ioutil.WriteFile(path.Join(inputDir, "TEST_1"), b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, err := readManifest(outputDir)
+	if err != nil || prev == nil {
+		t.Fatalf("readManifest = %v, %v", prev, err)
+	}
+	images := prev.Files["TEST_1"].Images
+	if len(images) != 2 {
+		t.Fatalf("images of TEST_1 in manifest = %v, want 2 distinct images", images)
+	}
+
+	// 重複を記録していた以前のマニフェストも再利用できる
+	prev.Files["TEST_1"].Images = append(images, images[0])
+	err = prev.write(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marker := path.Join(outputDir, images[0])
+	err = ioutil.WriteFile(marker, []byte("reused"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(marker); string(b) != "reused" {
+		t.Errorf("TEST_1 with duplicate keys was rebuilt, want reused")
+	}
+
+	records := readJSONMetadata(t, path.Join(outputDir, "test.json"))
+	if len(records) != 2+testRecordNum {
+		t.Errorf("%d records in metadata, want %d", len(records), 2+testRecordNum)
+	}
+}
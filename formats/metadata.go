@@ -0,0 +1,309 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// MetadataFormat メタデータの出力形式
+type MetadataFormat string
+
+const (
+	// MetadataFormatJSON レコードをJSONの配列として出力する e.g) etl9g.json
+	MetadataFormatJSON MetadataFormat = "json"
+
+	// MetadataFormatJSONL レコードを1行に1件ずつJSONで出力する(JSON Lines) e.g) etl9g.jsonl
+	MetadataFormatJSONL MetadataFormat = "jsonl"
+
+	// MetadataFormatCSV レコードをCSVで出力する e.g) etl9g.csv
+	// 列はレコードの構造体のjsonタグから決まる
+	MetadataFormatCSV MetadataFormat = "csv"
+
+	// MetadataFormatTSV レコードをTSVで出力する e.g) etl9g.tsv
+	MetadataFormatTSV MetadataFormat = "tsv"
+)
+
+// metadataWriter レコードのメタデータを1件ずつ出力する
+type metadataWriter interface {
+	// WriteRecord レコードを出力する
+	// - rjb: json.Marshalしたレコード
+	WriteRecord(rjb []byte) error
+
+	// Close 出力を終えてファイルを閉じる
+	Close() error
+}
+
+// metadataFormats 対応しているメタデータの出力形式
+var metadataFormats = []MetadataFormat{MetadataFormatJSON, MetadataFormatJSONL, MetadataFormatCSV, MetadataFormatTSV}
+
+// metadataFileName メタデータのファイル名 e.g) etl9g.json
+// - name: フォーマット名
+// - format: メタデータの出力形式
+func metadataFileName(name string, format MetadataFormat) string {
+	return fmt.Sprintf("%s.%s", strings.ToLower(name), format)
+}
+
+// checkMetadataFormat 対応しているメタデータの出力形式かどうか確認する
+func checkMetadataFormat(format MetadataFormat) error {
+	switch format {
+	case MetadataFormatJSON, MetadataFormatJSONL, MetadataFormatCSV, MetadataFormatTSV:
+		return nil
+	}
+	return fmt.Errorf("unknown metadata format: %s", format)
+}
+
+// reusableMetadataFormat 出力するメタデータのうち, 再利用時にレコードを読み込む形式
+// JSON Linesを優先し, JSONとJSON Linesのどちらも出力しない場合は空を返す
+// - formats: 出力するメタデータの形式
+func reusableMetadataFormat(formats []MetadataFormat) MetadataFormat {
+	var reusable MetadataFormat
+	for _, format := range formats {
+		switch format {
+		case MetadataFormatJSONL:
+			return format
+		case MetadataFormatJSON:
+			reusable = format
+		}
+	}
+	return reusable
+}
+
+// removeUnusedMetadata 今回出力しなかった形式のメタデータを削除する
+// 前回出力した形式のメタデータが古いレコードのまま残らないようにする
+// - outputDir: データセットを出力したディレクトリパス
+// - name: フォーマット名
+// - formats: 今回出力したメタデータの形式
+func removeUnusedMetadata(outputDir, name string, formats []MetadataFormat) error {
+	written := map[MetadataFormat]bool{}
+	for _, format := range formats {
+		written[format] = true
+	}
+	for _, format := range metadataFormats {
+		if written[format] {
+			continue
+		}
+		err := os.Remove(path.Join(outputDir, metadataFileName(name, format)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// newMetadataWriter メタデータの出力形式に応じたmetadataWriterを生成する
+// - outputDir: データセットを出力するディレクトリパス
+// - name: フォーマット名
+// - format: メタデータの出力形式
+func newMetadataWriter(outputDir, name string, format MetadataFormat) (metadataWriter, error) {
+	err := checkMetadataFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path.Join(outputDir, metadataFileName(name, format)))
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case MetadataFormatJSONL:
+		return &jsonLinesWriter{f: f, w: bufio.NewWriter(f)}, nil
+	case MetadataFormatCSV:
+		return newCSVWriter(f, ','), nil
+	case MetadataFormatTSV:
+		return newCSVWriter(f, '\t'), nil
+	default:
+		return &jsonArrayWriter{f: f, w: bufio.NewWriter(f)}, nil
+	}
+}
+
+// jsonArrayWriter レコードをJSONの配列として出力する
+type jsonArrayWriter struct {
+	f *os.File
+	w *bufio.Writer
+	n int
+}
+
+func (w *jsonArrayWriter) WriteRecord(rjb []byte) error {
+	var err error
+
+	// 先頭に`[`を付加し, 2件目以降はカンマで区切る
+	sep := ",\n"
+	if w.n == 0 {
+		sep = "[\n"
+	}
+	_, err = w.w.WriteString(sep)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(rjb)
+	if err != nil {
+		return err
+	}
+	w.n++
+	return nil
+}
+
+func (w *jsonArrayWriter) Close() error {
+	var err error
+
+	// 終端に`]`を付加
+	end := "\n]"
+	if w.n == 0 {
+		end = "[\n]"
+	}
+	_, err = w.w.WriteString(end)
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+
+	err = w.w.Flush()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// jsonLinesWriter レコードを1行に1件ずつJSONで出力する
+type jsonLinesWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func (w *jsonLinesWriter) WriteRecord(rjb []byte) error {
+	var err error
+
+	_, err = w.w.Write(rjb)
+	if err != nil {
+		return err
+	}
+	return w.w.WriteByte('\n')
+}
+
+func (w *jsonLinesWriter) Close() error {
+	err := w.w.Flush()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// csvWriter レコードをCSV(TSV)で出力する
+// 1件目のレコードのキーを列名としてヘッダ行に出力する.
+// json.Marshalは構造体のフィールド順にキーを出力するため, 列の順序はレコードの構造体の定義順になる
+type csvWriter struct {
+	f      *os.File
+	w      *csv.Writer
+	header []string
+	index  map[string]int
+}
+
+// newCSVWriter csvWriterを生成する
+// - f: 出力先のファイル
+// - comma: 区切り文字
+func newCSVWriter(f *os.File, comma rune) *csvWriter {
+	w := csv.NewWriter(f)
+	w.Comma = comma
+	return &csvWriter{f: f, w: w}
+}
+
+func (w *csvWriter) WriteRecord(rjb []byte) error {
+	keys, values, err := decodeOrderedObject(rjb)
+	if err != nil {
+		return err
+	}
+
+	if w.header == nil {
+		w.header = keys
+		w.index = make(map[string]int, len(keys))
+		for i, key := range keys {
+			w.index[key] = i
+		}
+		err = w.w.Write(w.header)
+		if err != nil {
+			return err
+		}
+	}
+
+	row := make([]string, len(w.header))
+	for i, key := range keys {
+		j, ok := w.index[key]
+		if !ok {
+			continue
+		}
+		row[j], err = csvCell(values[i])
+		if err != nil {
+			return err
+		}
+	}
+	return w.w.Write(row)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	err := w.w.Error()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// decodeOrderedObject JSONオブジェクトをキーの出現順にデコードする
+// - rjb: JSONオブジェクト
+func decodeOrderedObject(rjb []byte) ([]string, []json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(rjb))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, fmt.Errorf("metadata is not a JSON object: %s", rjb)
+	}
+
+	keys := []string{}
+	values := []json.RawMessage{}
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("unexpected token in metadata: %v", tok)
+		}
+
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
+
+// csvCell JSONの値をCSVのセルの文字列にする
+// 文字列はそのまま, nullは空文字, 数値や配列はJSONの表記のまま出力する
+func csvCell(value json.RawMessage) (string, error) {
+	if len(value) == 0 || string(value) == "null" {
+		return "", nil
+	}
+	if value[0] == '"' {
+		var s string
+		err := json.Unmarshal(value, &s)
+		return s, err
+	}
+	return string(value), nil
+}
@@ -0,0 +1,263 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// metadataImageNames メタデータの各レコードのimage_nameを取り出す
+type metadataImageNames func(t *testing.T, b []byte) []string
+
+// jsonImageNames JSONの配列からimage_nameを取り出す
+func jsonImageNames(t *testing.T, b []byte) []string {
+	records := []struct {
+		ImageName string `json:"image_name"`
+	}{}
+	err := json.Unmarshal(b, &records)
+	if err != nil {
+		t.Fatalf("invalid JSON array: %v\n%s", err, b)
+	}
+	names := []string{}
+	for _, record := range records {
+		names = append(names, record.ImageName)
+	}
+	return names
+}
+
+// jsonLinesImageNames JSON Linesの各行からimage_nameを取り出す
+func jsonLinesImageNames(t *testing.T, b []byte) []string {
+	names := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, len(b)+1)
+	for scanner.Scan() {
+		var record struct {
+			ImageName string `json:"image_name"`
+		}
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			t.Fatalf("invalid JSON line: %v\n%s", err, scanner.Bytes())
+		}
+		names = append(names, record.ImageName)
+	}
+	return names
+}
+
+// csvImageNames CSV(TSV)のimage_nameの列を取り出す. 列名はレコードの構造体の定義順
+func csvImageNames(comma rune) metadataImageNames {
+	return func(t *testing.T, b []byte) []string {
+		r := csv.NewReader(bytes.NewReader(b))
+		r.Comma = comma
+		rows, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("invalid CSV: %v\n%s", err, b)
+		}
+		if len(rows) == 0 {
+			t.Fatal("header is missing")
+		}
+
+		wantHeader := []string{"format", "character", "image_name", "image_width", "image_height", "data_number"}
+		header := rows[0]
+		if len(header) < len(wantHeader) {
+			t.Fatalf("header = %q, want prefix %q", header, wantHeader)
+		}
+		for i, name := range wantHeader {
+			if header[i] != name {
+				t.Fatalf("header = %q, want prefix %q", header, wantHeader)
+			}
+		}
+
+		names := []string{}
+		for _, row := range rows[1:] {
+			if row[0] != "7" {
+				t.Errorf("format = %q, want %q", row[0], "7")
+			}
+			names = append(names, row[2])
+		}
+		return names
+	}
+}
+
+func TestMetadataFormats(t *testing.T) {
+	cases := []struct {
+		format     MetadataFormat
+		imageNames metadataImageNames
+	}{
+		{MetadataFormatJSON, jsonImageNames},
+		{MetadataFormatJSONL, jsonLinesImageNames},
+		{MetadataFormatCSV, csvImageNames(',')},
+		{MetadataFormatTSV, csvImageNames('\t')},
+	}
+
+	inputDir := writeTestInput(t)
+	defer os.RemoveAll(inputDir)
+	outputDir, err := ioutil.TempDir("", "etlcdb-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	// TEST_2の末尾のレコードを欠けさせて読み飛ばす
+	input2 := path.Join(inputDir, "TEST_2")
+	b, err := ioutil.ReadFile(input2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(input2, b[:len(b)-1], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := []MetadataFormat{}
+	for _, c := range cases {
+		formats = append(formats, c.format)
+	}
+	skipped := 0
+	err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{
+		SkipCorruptRecords: true,
+		OnSkip:             func(err *RecordError) { skipped++ },
+		MetadataFormats:    formats,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 {
+		t.Errorf("%d records skipped, want 1", skipped)
+	}
+
+	m, err := readManifest(outputDir)
+	if err != nil || m == nil {
+		t.Fatalf("readManifest = %v, %v", m, err)
+	}
+	images := []string{}
+	for _, fileName := range []string{"TEST_1", "TEST_2"} {
+		images = append(images, m.Files[fileName].Images...)
+	}
+	if want := 2*testRecordNum - 1; len(images) != want {
+		t.Fatalf("%d images in manifest, want %d", len(images), want)
+	}
+
+	for _, c := range cases {
+		b, err := ioutil.ReadFile(path.Join(outputDir, metadataFileName("TEST", c.format)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := c.imageNames(t, b)
+		if len(names) != len(images) {
+			t.Errorf("%s: %d records, want %d", c.format, len(names), len(images))
+			continue
+		}
+
+		// 出力済みの画像のレコードだけが含まれる
+		want := map[string]bool{}
+		for _, image := range images {
+			want[image] = true
+		}
+		for _, name := range names {
+			if !want[name] {
+				t.Errorf("%s: unexpected record %q", c.format, name)
+			}
+			delete(want, name)
+		}
+	}
+}
+
+func TestJSONArrayWriterEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := newMetadataWriter(dir, "TEST", MetadataFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path.Join(dir, "test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := jsonImageNames(t, b); len(names) != 0 {
+		t.Errorf("%d records, want 0", len(names))
+	}
+}
+
+func TestMetadataFormatsChanged(t *testing.T) {
+	cases := []struct {
+		formats      []MetadataFormat
+		wantMetadata MetadataFormat
+	}{
+		{[]MetadataFormat{MetadataFormatJSON, MetadataFormatJSONL}, MetadataFormatJSONL},
+		{[]MetadataFormat{MetadataFormatJSON}, MetadataFormatJSON},
+		{[]MetadataFormat{MetadataFormatJSONL}, MetadataFormatJSONL},
+	}
+
+	inputDir := writeTestInput(t)
+	defer os.RemoveAll(inputDir)
+	outputDir, err := ioutil.TempDir("", "etlcdb-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	// 同じ出力ディレクトリへ形式を変えて続けて出力する
+	for _, c := range cases {
+		err = MakeDatasetsWithOptions(testFormat, inputDir, outputDir, DatasetsOptions{MetadataFormats: c.formats})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := readManifest(outputDir)
+		if err != nil || m == nil {
+			t.Fatalf("readManifest = %v, %v", m, err)
+		}
+		if m.Metadata != c.wantMetadata {
+			t.Errorf("%v: manifest metadata = %q, want %q", c.formats, m.Metadata, c.wantMetadata)
+		}
+
+		// 指定しなかった形式のメタデータは残らない
+		written := map[MetadataFormat]bool{}
+		for _, format := range c.formats {
+			written[format] = true
+		}
+		for _, format := range metadataFormats {
+			_, err := os.Stat(path.Join(outputDir, metadataFileName("TEST", format)))
+			if exists := err == nil; exists != written[format] {
+				t.Errorf("%v: %s exists = %v, want %v", c.formats, format, exists, written[format])
+			}
+		}
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{`"あ"`, "あ"},
+		{`"a,\"b\""`, `a,"b"`},
+		{`null`, ""},
+		{`12`, "12"},
+		{`[1,2]`, "[1,2]"},
+	}
+	for _, c := range cases {
+		got, err := csvCell(json.RawMessage(c.value))
+		if err != nil {
+			t.Errorf("csvCell(%s): %v", c.value, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("csvCell(%s) = %q, want %q", c.value, got, c.want)
+		}
+	}
+}
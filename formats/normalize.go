@@ -0,0 +1,138 @@
+package formats
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// defaultInkThreshold NormalizeOptions.InkThresholdを指定しなかった場合の値. 4bitの画像では4以上がインクになる
+const defaultInkThreshold = 64
+
+// NormalizeOptions リサイズ前に文字の位置を揃える前処理のオプション
+// ETLの画像は黒(0)の背景に明るい画素で文字が書かれている. ゼロ値の場合は前処理をしない
+type NormalizeOptions struct {
+	// CropInk trueの場合はインクの外接矩形で切り取る
+	CropInk bool
+
+	// InkMargin CropInkで外接矩形の周りに残す余白のピクセル数
+	InkMargin int
+
+	// InkThreshold インクとみなす8bit換算の画素値の下限. 0の場合はdefaultInkThreshold
+	InkThreshold int
+
+	// CenterOfMass trueの場合はインクの重心が中央になるよう正方形のキャンバスへ配置する(MNISTと同様の正規化)
+	// CropInkと併用した場合は切り取った範囲がすべて収まる大きさのキャンバスにする
+	CenterOfMass bool
+}
+
+// checkNormalizeOptions 前処理のオプションを確認する
+func checkNormalizeOptions(opts *NormalizeOptions) error {
+	if opts.InkMargin < 0 {
+		return fmt.Errorf("invalid ink margin: %d", opts.InkMargin)
+	}
+	return nil
+}
+
+// normalizeImage インクの外接矩形での切り取りと重心での中央揃えを行う
+// インクが見つからない場合は何もしない. 画像の外は背景(画素値0もしくはパレットの0番)で埋める
+// - img: 画像データ
+// - opts: オプション
+func normalizeImage(img image.Image, opts *NormalizeOptions) image.Image {
+	if !opts.CropInk && !opts.CenterOfMass {
+		return img
+	}
+	threshold := opts.InkThreshold
+	if threshold <= 0 {
+		threshold = defaultInkThreshold
+	}
+
+	// 画素の値と輝度の対応
+	var luma [256]uint8
+	var src *image.Gray
+	var palette color.Palette
+	switch img := img.(type) {
+	case *image.Paletted:
+		src = &image.Gray{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+		palette = img.Palette
+		for i, c := range palette {
+			luma[i] = color.GrayModel.Convert(c).(color.Gray).Y
+		}
+	default:
+		src = toGray(img)
+		for i := range luma {
+			luma[i] = uint8(i)
+		}
+	}
+
+	// インクの外接矩形と重心
+	rect := src.Rect
+	ink := image.Rectangle{Min: rect.Max, Max: rect.Min}
+	var sum, sumX, sumY float64
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := src.Pix[src.PixOffset(rect.Min.X, y):src.PixOffset(rect.Max.X, y)]
+		for i, v := range row {
+			l := luma[v]
+			if int(l) < threshold {
+				continue
+			}
+			x := rect.Min.X + i
+			ink.Min.X = minInt(ink.Min.X, x)
+			ink.Min.Y = minInt(ink.Min.Y, y)
+			ink.Max.X = maxInt(ink.Max.X, x+1)
+			ink.Max.Y = maxInt(ink.Max.Y, y+1)
+			sum += float64(l)
+			sumX += float64(l) * (float64(x) + 0.5)
+			sumY += float64(l) * (float64(y) + 0.5)
+		}
+	}
+	if sum == 0 {
+		return img
+	}
+
+	// 出力する範囲(元の画像の座標)
+	area := rect
+	if opts.CropInk {
+		area = ink.Inset(-opts.InkMargin)
+	}
+	if opts.CenterOfMass {
+		cx, cy := sumX/sum, sumY/sum
+		half := math.Max(
+			math.Max(cx-float64(area.Min.X), float64(area.Max.X)-cx),
+			math.Max(cy-float64(area.Min.Y), float64(area.Max.Y)-cy),
+		)
+		// 原点を整数へ丸めても端が欠けないよう1ピクセル広げる. 丸めるため重心は中央から0.5ピクセル以内になる
+		side := int(math.Ceil(2*half)) + 1
+		origin := image.Pt(int(math.Round(cx-float64(side)/2)), int(math.Round(cy-float64(side)/2)))
+		area = image.Rectangle{Min: origin, Max: origin.Add(image.Pt(side, side))}
+	}
+
+	// 範囲を原点から始まる画像へ切り出す
+	dst := image.NewGray(image.Rect(0, 0, area.Dx(), area.Dy()))
+	overlap := area.Intersect(rect)
+	for y := overlap.Min.Y; y < overlap.Max.Y; y++ {
+		copy(
+			dst.Pix[dst.PixOffset(overlap.Min.X-area.Min.X, y-area.Min.Y):],
+			src.Pix[src.PixOffset(overlap.Min.X, y):src.PixOffset(overlap.Max.X, y)],
+		)
+	}
+	if palette != nil {
+		return &image.Paletted{Pix: dst.Pix, Stride: dst.Stride, Rect: dst.Rect, Palette: palette}
+	}
+	return dst
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,174 @@
+package formats
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// inkImage 指定した座標の画素を指定した値にした10x10のグレイスケール画像
+func inkImage(pixels map[image.Point]uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 10, 10))
+	for p, v := range pixels {
+		img.Pix[img.PixOffset(p.X, p.Y)] = v
+	}
+	return img
+}
+
+// inkCenter しきい値以上の画素の合計と重心
+func inkCenter(img *image.Gray, threshold uint8) (int, float64, float64) {
+	sum, sumX, sumY := 0, 0.0, 0.0
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			v := img.GrayAt(x, y).Y
+			if v < threshold {
+				continue
+			}
+			sum += int(v)
+			sumX += float64(v) * (float64(x) + 0.5)
+			sumY += float64(v) * (float64(y) + 0.5)
+		}
+	}
+	if sum == 0 {
+		return 0, 0, 0
+	}
+	return sum, sumX / float64(sum), sumY / float64(sum)
+}
+
+func TestNormalizeImageCropInk(t *testing.T) {
+	cases := []struct {
+		name   string
+		pixels map[image.Point]uint8
+		opts   NormalizeOptions
+		size   image.Point
+		// ink 切り取った画像でインクがある座標
+		ink []image.Point
+	}{
+		{
+			name:   "crop",
+			pixels: map[image.Point]uint8{{3, 2}: 255, {5, 6}: 128},
+			opts:   NormalizeOptions{CropInk: true},
+			size:   image.Pt(3, 5),
+			ink:    []image.Point{{0, 0}, {2, 4}},
+		},
+		{
+			name:   "margin",
+			pixels: map[image.Point]uint8{{3, 2}: 255, {5, 6}: 128},
+			opts:   NormalizeOptions{CropInk: true, InkMargin: 1},
+			size:   image.Pt(5, 7),
+			ink:    []image.Point{{1, 1}, {3, 5}},
+		},
+		{
+			// 画像の外は背景で埋める
+			name:   "margin outside image",
+			pixels: map[image.Point]uint8{{0, 0}: 255},
+			opts:   NormalizeOptions{CropInk: true, InkMargin: 2},
+			size:   image.Pt(5, 5),
+			ink:    []image.Point{{2, 2}},
+		},
+		{
+			// しきい値未満の画素はインクとみなさない
+			name:   "below threshold",
+			pixels: map[image.Point]uint8{{3, 2}: 255, {9, 9}: 32},
+			opts:   NormalizeOptions{CropInk: true},
+			size:   image.Pt(1, 1),
+			ink:    []image.Point{{0, 0}},
+		},
+		{
+			name:   "threshold",
+			pixels: map[image.Point]uint8{{3, 2}: 255, {9, 9}: 32},
+			opts:   NormalizeOptions{CropInk: true, InkThreshold: 16},
+			size:   image.Pt(7, 8),
+			ink:    []image.Point{{0, 0}, {6, 7}},
+		},
+	}
+	for _, c := range cases {
+		got := toGray(normalizeImage(inkImage(c.pixels), &c.opts))
+		if size := got.Bounds().Size(); size != c.size {
+			t.Errorf("%s: size = %v, want %v", c.name, size, c.size)
+			continue
+		}
+		ink := map[image.Point]bool{}
+		for _, p := range c.ink {
+			ink[p] = true
+		}
+		for y := 0; y < c.size.Y; y++ {
+			for x := 0; x < c.size.X; x++ {
+				if v := got.GrayAt(x, y).Y; (v != 0) != ink[image.Pt(x, y)] {
+					t.Errorf("%s: (%d, %d) = %d", c.name, x, y, v)
+				}
+			}
+		}
+	}
+}
+
+func TestNormalizeImageCenterOfMass(t *testing.T) {
+	cases := []struct {
+		name   string
+		pixels map[image.Point]uint8
+		opts   NormalizeOptions
+		side   int
+	}{
+		// 重心(2.5, 3.5)から最も遠い端まで7.5
+		{"corner", map[image.Point]uint8{{2, 3}: 255}, NormalizeOptions{CenterOfMass: true}, 16},
+		// 重心(3.25, 3.25)から最も遠い端まで6.75
+		{"weighted", map[image.Point]uint8{{1, 1}: 255, {8, 8}: 85}, NormalizeOptions{CenterOfMass: true}, 15},
+		// 切り取った範囲(3, 2)-(6, 7)が収まるよう重心(4.5, 4.5)から2.5
+		{"with crop", map[image.Point]uint8{{3, 2}: 255, {5, 6}: 255}, NormalizeOptions{CropInk: true, CenterOfMass: true}, 6},
+	}
+	for _, c := range cases {
+		src := inkImage(c.pixels)
+		got := toGray(normalizeImage(src, &c.opts))
+		if size := got.Bounds().Size(); size != image.Pt(c.side, c.side) {
+			t.Errorf("%s: size = %v, want %dx%d", c.name, size, c.side, c.side)
+			continue
+		}
+
+		// インクはすべて残り, 重心は中央から0.5ピクセル以内
+		wantSum, _, _ := inkCenter(src, 1)
+		sum, cx, cy := inkCenter(got, 1)
+		if sum != wantSum {
+			t.Errorf("%s: ink sum = %d, want %d", c.name, sum, wantSum)
+		}
+		center := float64(c.side) / 2
+		if math.Abs(cx-center) > 0.5 || math.Abs(cy-center) > 0.5 {
+			t.Errorf("%s: center of mass = (%.2f, %.2f), want (%.1f, %.1f)", c.name, cx, cy, center, center)
+		}
+	}
+}
+
+func TestNormalizeImageNoInk(t *testing.T) {
+	src := inkImage(map[image.Point]uint8{{3, 3}: 32})
+	got := normalizeImage(src, &NormalizeOptions{CropInk: true, CenterOfMass: true})
+	if got != image.Image(src) {
+		t.Error("image without ink was changed")
+	}
+}
+
+func TestNormalizeImagePaletted(t *testing.T) {
+	// 元のビット深度の画像はパレットと元の値のまま切り取る
+	src := image.NewPaletted(image.Rect(0, 0, 10, 10), grayPalette(4))
+	src.Pix[src.PixOffset(3, 2)] = 15
+	src.Pix[src.PixOffset(5, 6)] = 8
+	got, ok := normalizeImage(src, &NormalizeOptions{CropInk: true}).(*image.Paletted)
+	if !ok {
+		t.Fatal("normalized image is not paletted")
+	}
+	pix, max, ok := nativeGrayPixels(got)
+	if !ok || max != 15 {
+		t.Fatalf("nativeGrayPixels = %v, %d, %v", pix, max, ok)
+	}
+	if len(pix) != 15 || pix[0] != 15 || pix[14] != 8 {
+		t.Errorf("pixels = %v, want 3x5 with 15 first and 8 last", pix)
+	}
+}
+
+func TestCheckNormalizeOptions(t *testing.T) {
+	if err := checkNormalizeOptions(&NormalizeOptions{CropInk: true, InkMargin: -1}); err == nil {
+		t.Error("negative ink margin was accepted")
+	}
+	if err := checkNormalizeOptions(&NormalizeOptions{CropInk: true, InkMargin: 2}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,146 @@
+package formats
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ResizeFilter リサイズに使うフィルタ
+type ResizeFilter string
+
+const (
+	// ResizeFilterNearest 最近傍補間. 2値画像の値を保ちたい場合に使う
+	ResizeFilterNearest ResizeFilter = "nearest"
+
+	// ResizeFilterBox ボックスフィルタ. 縮小向け
+	ResizeFilterBox ResizeFilter = "box"
+
+	// ResizeFilterLinear バイリニア補間
+	ResizeFilterLinear ResizeFilter = "linear"
+
+	// ResizeFilterCatmullRom Catmull-Romスプライン
+	ResizeFilterCatmullRom ResizeFilter = "catmullrom"
+
+	// ResizeFilterLanczos Lanczos(3-lobe). デフォルト
+	ResizeFilterLanczos ResizeFilter = "lanczos"
+)
+
+// ResizeMode 元の画像と出力する画像のアスペクト比が異なる場合のリサイズ方法
+type ResizeMode string
+
+const (
+	// ResizeModeStretch アスペクト比を無視して幅と高さに合わせる. デフォルト
+	ResizeModeStretch ResizeMode = "stretch"
+
+	// ResizeModeFit アスペクト比を維持して幅と高さに収まるよう拡大縮小し, 余白をPadColorで埋める
+	ResizeModeFit ResizeMode = "fit"
+
+	// ResizeModeCrop アスペクト比を維持して幅と高さを覆うよう拡大縮小し, はみ出した部分を中央から切り取る
+	ResizeModeCrop ResizeMode = "crop"
+)
+
+// ResizeOptions リサイズのオプション. ゼロ値の場合はLanczosで幅と高さに合わせる
+type ResizeOptions struct {
+	// Filter フィルタ. 空の場合はResizeFilterLanczos
+	Filter ResizeFilter
+
+	// Mode リサイズ方法. 空の場合はResizeModeStretch. 幅と高さのどちらかが0の場合はアスペクト比を維持するため無視する
+	Mode ResizeMode
+
+	// PadColor ResizeModeFitで余白を埋める色. nilの場合は黒(ETLの画像の背景と同じ)
+	PadColor color.Color
+}
+
+// resizeFilters ResizeFilterとimagingのフィルタの対応
+var resizeFilters = map[ResizeFilter]imaging.ResampleFilter{
+	ResizeFilterNearest:    imaging.NearestNeighbor,
+	ResizeFilterBox:        imaging.Box,
+	ResizeFilterLinear:     imaging.Linear,
+	ResizeFilterCatmullRom: imaging.CatmullRom,
+	ResizeFilterLanczos:    imaging.Lanczos,
+}
+
+// checkResizeOptions 対応しているリサイズのオプションかどうか確認する
+func checkResizeOptions(opts *ResizeOptions) error {
+	if _, ok := resizeFilters[opts.Filter]; !ok && opts.Filter != "" {
+		return fmt.Errorf("unknown resize filter: %s", opts.Filter)
+	}
+	switch opts.Mode {
+	case "", ResizeModeStretch, ResizeModeFit, ResizeModeCrop:
+		return nil
+	}
+	return fmt.Errorf("unknown resize mode: %s", opts.Mode)
+}
+
+// padColorString 余白の色をマニフェストに記録する文字列にする. デフォルトの黒の場合は空文字
+func padColorString(c color.Color) string {
+	if c == nil {
+		return ""
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n == (color.NRGBA{A: 0xff}) {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+// resizeImageWith オプションに従って画像をリサイズする
+// width, heightが共に0もしくは元画像と同じサイズの場合はリサイズしない
+// どちらか一方が0の場合はアスペクト比を維持してリサイズする
+// パレットを持つ画像の場合はリサイズ後に同じパレットの最も近い色にする
+// - img: 画像データ
+// - width: リサイズ後の画像の幅
+// - height: リサイズ後の画像の高さ
+// - opts: オプション
+func resizeImageWith(img image.Image, width, height int, opts *ResizeOptions) image.Image {
+	b := img.Bounds()
+	if (width == 0 && height == 0) || (width == b.Dx() && height == b.Dy()) {
+		return img
+	}
+
+	filter, ok := resizeFilters[opts.Filter]
+	if !ok {
+		filter = imaging.Lanczos
+	}
+
+	var resized image.Image
+	if width == 0 || height == 0 || opts.Mode == "" || opts.Mode == ResizeModeStretch {
+		resized = imaging.Resize(img, width, height, filter)
+	} else {
+		// 幅と高さの拡大率のうち, fitでは小さい方, cropでは大きい方に合わせる
+		sx := float64(width) / float64(b.Dx())
+		sy := float64(height) / float64(b.Dy())
+		scale := math.Min(sx, sy)
+		if opts.Mode == ResizeModeCrop {
+			scale = math.Max(sx, sy)
+		}
+		w := int(math.Max(1, math.Round(float64(b.Dx())*scale)))
+		h := int(math.Max(1, math.Round(float64(b.Dy())*scale)))
+		scaled := imaging.Resize(img, w, h, filter)
+
+		canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+		if opts.Mode == ResizeModeFit {
+			padColor := opts.PadColor
+			if padColor == nil {
+				padColor = color.Black
+			}
+			draw.Draw(canvas, canvas.Bounds(), image.NewUniform(padColor), image.Point{}, draw.Src)
+		}
+		offset := image.Pt((width-w)/2, (height-h)/2)
+		draw.Draw(canvas, scaled.Bounds().Add(offset), scaled, scaled.Bounds().Min, draw.Src)
+		resized = canvas
+	}
+
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		return resized
+	}
+	quantized := image.NewPaletted(resized.Bounds(), p.Palette)
+	draw.Draw(quantized, quantized.Bounds(), resized, resized.Bounds().Min, draw.Src)
+	return quantized
+}
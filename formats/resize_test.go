@@ -0,0 +1,176 @@
+package formats
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// halfImage 左半分が0, 右半分が255のグレイスケール画像
+func halfImage(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := width / 2; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	return img
+}
+
+// uniformImage すべての画素がvのグレイスケール画像
+func uniformImage(width, height int, v uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = v
+	}
+	return img
+}
+
+func TestResizeImageWithSize(t *testing.T) {
+	src := halfImage(40, 20)
+	cases := []struct {
+		width, height int
+		opts          ResizeOptions
+		want          image.Point
+	}{
+		{0, 0, ResizeOptions{}, image.Pt(40, 20)},
+		{40, 20, ResizeOptions{Mode: ResizeModeFit}, image.Pt(40, 20)},
+		{10, 10, ResizeOptions{}, image.Pt(10, 10)},
+		{10, 10, ResizeOptions{Mode: ResizeModeFit}, image.Pt(10, 10)},
+		{10, 10, ResizeOptions{Mode: ResizeModeCrop}, image.Pt(10, 10)},
+		// どちらか一方が0の場合はアスペクト比を維持する
+		{20, 0, ResizeOptions{Mode: ResizeModeFit}, image.Pt(20, 10)},
+		{0, 5, ResizeOptions{}, image.Pt(10, 5)},
+	}
+	for _, c := range cases {
+		got := resizeImageWith(src, c.width, c.height, &c.opts).Bounds().Size()
+		if got != c.want {
+			t.Errorf("%dx%d %+v: size = %v, want %v", c.width, c.height, c.opts, got, c.want)
+		}
+	}
+}
+
+func TestResizeImageWithMode(t *testing.T) {
+	white := uniformImage(40, 20, 255)
+	cases := []struct {
+		name string
+		src  image.Image
+		opts ResizeOptions
+		// want 10x10へリサイズした画像の座標ごとの画素値
+		want func(x, y int) uint8
+	}{
+		{
+			// 10x5に縮小して上下に2, 3ピクセルの余白
+			name: "fit",
+			src:  white,
+			opts: ResizeOptions{Filter: ResizeFilterNearest, Mode: ResizeModeFit},
+			want: func(x, y int) uint8 {
+				if y < 2 || y >= 7 {
+					return 0
+				}
+				return 255
+			},
+		},
+		{
+			name: "fit with pad color",
+			src:  white,
+			opts: ResizeOptions{Filter: ResizeFilterNearest, Mode: ResizeModeFit, PadColor: color.Gray{Y: 128}},
+			want: func(x, y int) uint8 {
+				if y < 2 || y >= 7 {
+					return 128
+				}
+				return 255
+			},
+		},
+		{
+			// 20x10に縮小して中央の10x10を切り取る
+			name: "crop",
+			src:  halfImage(40, 20),
+			opts: ResizeOptions{Filter: ResizeFilterNearest, Mode: ResizeModeCrop},
+			want: func(x, y int) uint8 {
+				if x < 5 {
+					return 0
+				}
+				return 255
+			},
+		},
+		{
+			// 横方向のみ縮小する
+			name: "stretch",
+			src:  halfImage(40, 10),
+			opts: ResizeOptions{Filter: ResizeFilterNearest},
+			want: func(x, y int) uint8 {
+				if x < 5 {
+					return 0
+				}
+				return 255
+			},
+		},
+	}
+	for _, c := range cases {
+		got := toGray(resizeImageWith(c.src, 10, 10, &c.opts))
+		for y := 0; y < 10; y++ {
+			for x := 0; x < 10; x++ {
+				if v, want := got.GrayAt(x, y).Y, c.want(x, y); v != want {
+					t.Errorf("%s: (%d, %d) = %d, want %d", c.name, x, y, v, want)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeImageWithPaletted(t *testing.T) {
+	// パレットを持つ画像は同じパレットのまま返す
+	src := image.NewPaletted(image.Rect(0, 0, 40, 20), grayPalette(4))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i % 16)
+	}
+	for _, mode := range []ResizeMode{ResizeModeStretch, ResizeModeFit, ResizeModeCrop} {
+		got, ok := resizeImageWith(src, 10, 10, &ResizeOptions{Mode: mode}).(*image.Paletted)
+		if !ok {
+			t.Errorf("%s: resized image is not paletted", mode)
+			continue
+		}
+		if len(got.Palette) != len(src.Palette) {
+			t.Errorf("%s: palette size = %d, want %d", mode, len(got.Palette), len(src.Palette))
+		}
+		if _, _, ok := nativeGrayPixels(got); !ok {
+			t.Errorf("%s: native pixels are lost", mode)
+		}
+	}
+}
+
+func TestCheckResizeOptions(t *testing.T) {
+	cases := []struct {
+		opts    ResizeOptions
+		wantErr bool
+	}{
+		{ResizeOptions{}, false},
+		{ResizeOptions{Filter: ResizeFilterCatmullRom, Mode: ResizeModeCrop}, false},
+		{ResizeOptions{Filter: "bicubic"}, true},
+		{ResizeOptions{Mode: "pad"}, true},
+	}
+	for _, c := range cases {
+		if err := checkResizeOptions(&c.opts); (err != nil) != c.wantErr {
+			t.Errorf("checkResizeOptions(%+v) = %v, want error %v", c.opts, err, c.wantErr)
+		}
+	}
+}
+
+func TestPadColorString(t *testing.T) {
+	cases := []struct {
+		c    color.Color
+		want string
+	}{
+		{nil, ""},
+		{color.Black, ""},
+		{color.Gray{Y: 0}, ""},
+		{color.White, "#ffffffff"},
+		{color.Gray{Y: 128}, "#808080ff"},
+	}
+	for _, c := range cases {
+		if got := padColorString(c.c); got != c.want {
+			t.Errorf("padColorString(%v) = %q, want %q", c.c, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package formats
+
+import (
+	"image"
+	"image/png"
+	"path"
+	"strings"
+)
+
+// OutputSink データセットのサンプル(画像とメタデータ)の出力先
+// MakeDatasetsWithOptionsではNewImageSinkなど画像をファイルとして出力するSinkは複数のworkerから同時に呼び出す.
+// それ以外のSinkはWorkerNumに関わらず1つのworkerから入力ファイルの順番に呼び出す
+type OutputSink interface {
+	// WriteSample サンプルを出力する
+	// - key: サンプルのキー(Record.GetKey)
+	// - img: リサイズ済みの画像
+	// - metadata: レコード. json.Marshalしたものがメタデータになる
+	WriteSample(key string, img image.Image, metadata Record) error
+
+	// Close 出力を終える. IDXなどはここでファイルを書き終える
+	Close() error
+
+	// Abort 途中で失敗した場合に出力を終え, 書き込み途中の出力を破棄する. Closeの代わりに呼び出す
+	Abort() error
+}
+
+// imagePathSink 画像をファイルとして出力するOutputSink
+// マニフェストによる出力の再利用や古い画像の削除はimagePathSinkの場合のみ行う
+type imagePathSink interface {
+	OutputSink
+
+	// imageName キーの拡張子を画像の形式に合わせたファイル名
+	imageName(key string) string
+
+	// imagePath サンプルの画像の出力ディレクトリからの相対パス
+	imagePath(key string, metadata Record) string
+}
+
+// imageSink 画像をファイルとしてディレクトリへ出力するOutputSink. MakeDatasetsWithOptionsのデフォルト
+type imageSink struct {
+	outputDir string
+	layout    ImageLayout
+	encoder   ImageEncoder
+}
+
+// NewPngSink 画像をPNGでディレクトリへ出力するOutputSinkを生成する
+// 画像はlayoutに応じたディレクトリへ<key>のファイル名で出力する. メタデータは出力しない
+// - outputDir: 出力するディレクトリパス
+// - layout: 画像の配置. 空の場合はImageLayoutFlat
+func NewPngSink(outputDir string, layout ImageLayout) (OutputSink, error) {
+	return NewImageSink(outputDir, layout, &pngEncoder{level: png.BestCompression})
+}
+
+// NewImageSink 画像をencoderでエンコードしてディレクトリへ出力するOutputSinkを生成する
+// 画像はlayoutに応じたディレクトリへ, <key>の拡張子をencoderに合わせたファイル名で出力する. メタデータは出力しない
+// - outputDir: 出力するディレクトリパス
+// - layout: 画像の配置. 空の場合はImageLayoutFlat
+// - encoder: 画像のエンコーダ
+func NewImageSink(outputDir string, layout ImageLayout, encoder ImageEncoder) (OutputSink, error) {
+	if layout == "" {
+		layout = ImageLayoutFlat
+	}
+	err := checkImageLayout(layout)
+	if err != nil {
+		return nil, err
+	}
+	return &imageSink{outputDir: outputDir, layout: layout, encoder: encoder}, nil
+}
+
+func (s *imageSink) WriteSample(key string, img image.Image, metadata Record) error {
+	return outputImage(path.Join(s.outputDir, classDir(metadata, s.layout)), s.imageName(key), img, s.encoder)
+}
+
+func (s *imageSink) Close() error {
+	return nil
+}
+
+// Abort 出力済みの画像はマニフェストで再利用するため削除しない
+func (s *imageSink) Abort() error {
+	return nil
+}
+
+func (s *imageSink) imageName(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + s.encoder.Ext()
+}
+
+func (s *imageSink) imagePath(key string, metadata Record) string {
+	return path.Join(classDir(metadata, s.layout), s.imageName(key))
+}
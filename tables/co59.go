@@ -0,0 +1,63 @@
+package tables
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CO59Code CO-59コードの上位6bitと下位6bitから対応表のキーを生成する
+func CO59Code(hi, lo uint8) uint16 {
+	return uint16(hi&0x3f)<<6 | uint16(lo&0x3f)
+}
+
+// ParseCO59 euc_co59.dat(EUC-JP)をCO-59コードからUnicodeへの対応表にする
+// euc_co59.datは `文字:上位6bit,下位6bit` を空白区切りで並べたもの
+func ParseCO59(r io.Reader) (map[uint16]uint16, error) {
+	mapping := map[uint16]uint16{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		s := scanner.Bytes()
+		i := strings.LastIndexByte(string(s), ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("co59: could not parse %q", s)
+		}
+
+		u, err := decodeEUCJP(s[:i])
+		if err != nil {
+			return nil, err
+		}
+
+		hi, lo := uint8(0), uint8(0)
+		if _, err := fmt.Sscanf(string(s[i+1:]), "%d,%d", &hi, &lo); err != nil {
+			return nil, fmt.Errorf("co59: could not parse %q", s)
+		}
+
+		mapping[CO59Code(hi, lo)] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("co59: scanner error: %v", err)
+	}
+
+	return mapping, nil
+}
+
+// decodeEUCJP EUC-JPの1文字をJIS0208, JIS0201を用いてUnicodeへ変換する
+func decodeEUCJP(b []byte) (uint16, error) {
+	switch {
+	case len(b) == 1 && b[0] < 0x80:
+		return uint16(b[0]), nil
+	case len(b) == 2 && b[0] == 0x8e:
+		if u, ok := JIS0201[uint16(b[1])]; ok {
+			return u, nil
+		}
+	case len(b) == 2 && b[0] >= 0xa1 && b[1] >= 0xa1:
+		if u, ok := JIS0208[uint16(b[0]&0x7f)<<8|uint16(b[1]&0x7f)]; ok {
+			return u, nil
+		}
+	}
+	return 0, fmt.Errorf("co59: unknown EUC-JP character % x", b)
+}
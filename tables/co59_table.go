@@ -0,0 +1,7 @@
+package tables
+
+// CO59 CO-59コードからUnicodeへの組み込みの対応表. キーは CO59Code で生成した12bitの値
+// ETL2と共に配布されているeuc_co59.datから `go run maketables.go -co59 euc_co59.dat -out co59_table.go` で生成する.
+// euc_co59.datはETLの配布物に含まれリポジトリには含めていないため, 生成するまでは空.
+// 空の場合, ETL2の入力ディレクトリにeuc_co59.datがなければETL2のレコードのCharacterは空になる
+var CO59 = map[uint16]uint16{}
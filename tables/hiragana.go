@@ -0,0 +1,61 @@
+package tables
+
+// JIS0201Hiragana JIS X 0201のカタカナの文字コードからひらがなのUnicodeへの対応表
+// ETL4, ETL7はひらがなをカタカナの文字コードで格納しているため, JIS0201 の代わりに使う. 長音記号(0xb0)などひらがなのない文字は含まない
+var JIS0201Hiragana = map[uint16]uint16{
+	0x00a6: 0x3092, // を
+	0x00a7: 0x3041, // ぁ
+	0x00a8: 0x3043, // ぃ
+	0x00a9: 0x3045, // ぅ
+	0x00aa: 0x3047, // ぇ
+	0x00ab: 0x3049, // ぉ
+	0x00ac: 0x3083, // ゃ
+	0x00ad: 0x3085, // ゅ
+	0x00ae: 0x3087, // ょ
+	0x00af: 0x3063, // っ
+	0x00b1: 0x3042, // あ
+	0x00b2: 0x3044, // い
+	0x00b3: 0x3046, // う
+	0x00b4: 0x3048, // え
+	0x00b5: 0x304a, // お
+	0x00b6: 0x304b, // か
+	0x00b7: 0x304d, // き
+	0x00b8: 0x304f, // く
+	0x00b9: 0x3051, // け
+	0x00ba: 0x3053, // こ
+	0x00bb: 0x3055, // さ
+	0x00bc: 0x3057, // し
+	0x00bd: 0x3059, // す
+	0x00be: 0x305b, // せ
+	0x00bf: 0x305d, // そ
+	0x00c0: 0x305f, // た
+	0x00c1: 0x3061, // ち
+	0x00c2: 0x3064, // つ
+	0x00c3: 0x3066, // て
+	0x00c4: 0x3068, // と
+	0x00c5: 0x306a, // な
+	0x00c6: 0x306b, // に
+	0x00c7: 0x306c, // ぬ
+	0x00c8: 0x306d, // ね
+	0x00c9: 0x306e, // の
+	0x00ca: 0x306f, // は
+	0x00cb: 0x3072, // ひ
+	0x00cc: 0x3075, // ふ
+	0x00cd: 0x3078, // へ
+	0x00ce: 0x307b, // ほ
+	0x00cf: 0x307e, // ま
+	0x00d0: 0x307f, // み
+	0x00d1: 0x3080, // む
+	0x00d2: 0x3081, // め
+	0x00d3: 0x3082, // も
+	0x00d4: 0x3084, // や
+	0x00d5: 0x3086, // ゆ
+	0x00d6: 0x3088, // よ
+	0x00d7: 0x3089, // ら
+	0x00d8: 0x308a, // り
+	0x00d9: 0x308b, // る
+	0x00da: 0x308c, // れ
+	0x00db: 0x308d, // ろ
+	0x00dc: 0x308f, // わ
+	0x00dd: 0x3093, // ん
+}
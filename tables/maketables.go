@@ -12,7 +12,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+
+	"github.com/PyYoshi/etlcdb-tools/tables"
 )
 
 const jisx0208TxtURL = "http://ftp.unicode.org/Public/MAPPINGS/OBSOLETE/EASTASIA/JIS/JIS0208.TXT"
@@ -20,10 +23,12 @@ const jisx0201TxtURL = "http://ftp.unicode.org/Public/MAPPINGS/OBSOLETE/EASTASIA
 
 func init() {
 	flag.StringVar(&outPath, "out", "", "e.g) ./locales_generated.go")
+	flag.StringVar(&co59Path, "co59", "", "generate the CO-59 table from euc_co59.dat instead of the JIS tables")
 }
 
 var (
-	outPath string
+	outPath  string
+	co59Path string
 )
 
 type generator struct {
@@ -119,6 +124,11 @@ func main() {
 	g.Printf("// generated by go run maketables.go %s; DO NOT EDIT\n\n", strings.Join(os.Args[1:], " "))
 	g.Printf("package tables \n\n")
 
+	if co59Path != "" {
+		generateCO59(&g)
+		return
+	}
+
 	// JIS X 0208
 	mappingJisX0208, err := parseJisX0208()
 	if err != nil {
@@ -145,8 +155,43 @@ func main() {
 	}
 	g.Printf("}\n\n")
 
+	writeOutput(&g)
+}
+
+// generateCO59 euc_co59.datからCO-59の対応表を生成する
+func generateCO59(g *generator) {
+	f, err := os.Open(co59Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	mappingCO59, err := tables.ParseCO59(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 生成するたびに差分が出ないようキー順に出力する
+	codes := make([]int, 0, len(mappingCO59))
+	for c := range mappingCO59 {
+		codes = append(codes, int(c))
+	}
+	sort.Ints(codes)
+
+	g.Println("// CO59 CO-59コードからUnicodeへの組み込みの対応表. キーは CO59Code で生成した12bitの値")
+	g.Println("var CO59 = map[uint16]uint16{")
+	for _, c := range codes {
+		u := mappingCO59[uint16(c)]
+		g.Printf("\t0x%04x: 0x%04x, // %s\n", c, u, string(rune(u)))
+	}
+	g.Printf("}\n")
+
+	writeOutput(g)
+}
+
+func writeOutput(g *generator) {
 	dst := g.Format()
-	err = ioutil.WriteFile(outPath, dst, 0644)
+	err := ioutil.WriteFile(outPath, dst, 0644)
 	if err != nil {
 		log.Fatalf("writing output: %s", err)
 	}